@@ -0,0 +1,40 @@
+// Package ast traverses the syntax trees built by the parser package.
+package ast
+
+import "terminascript/parser"
+
+// A Visitor's Visit method is called for each node found by Walk. If the
+// visitor it returns is not nil, Walk visits each child of the node with it,
+// and then calls its Visit method with nil.
+type Visitor interface {
+	Visit(node parser.Node) (w Visitor)
+}
+
+// Walk traverses the tree below node in depth-first order, starting with
+// node itself.
+func Walk(v Visitor, node parser.Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range node.Children() {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(parser.Node) bool
+
+func (f inspector) Visit(node parser.Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for each node in the tree below node, in depth-first
+// order, starting with node itself. If f returns false the children of that
+// node are skipped. After the children of a node have been visited, f is
+// called with nil.
+func Inspect(node parser.Node, f func(parser.Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"terminascript/lexer"
+	"terminascript/parser"
+	"unicode"
+)
+
+// dumpCommand runs "terminascript tokens" or "terminascript ast", which
+// print the tokens or the syntax tree of a file, as text or with --json as
+// JSON. It returns the exit status, which is 1 if the file has errors.
+func dumpCommand(command string, args []string) int {
+	filename, asJSON, ok := dumpArguments(args)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "usage: terminascript %s [--json] file\n", command)
+		return 2
+	}
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tokens, diagnostics := lexer.NewLexer(filename, string(source)).Lex()
+	var output interface{}
+	if command == "tokens" {
+		if asJSON {
+			output = tokensJSON(tokens)
+		} else {
+			printTokens(os.Stdout, tokens)
+		}
+	} else {
+		program, syntax := parser.NewParser(tokens).Parse()
+		diagnostics = append(diagnostics, syntax...)
+		if asJSON {
+			output = nodeJSON(program)
+		} else {
+			printNode(os.Stdout, "", "", program)
+		}
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]interface{}{
+			"file":        filename,
+			command:       output,
+			"diagnostics": diagnosticsJSON(diagnostics),
+		})
+	} else {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+	}
+	if len(diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// dumpArguments accepts the file name and --json in either order.
+func dumpArguments(args []string) (string, bool, bool) {
+	filename, asJSON := "", false
+	for _, arg := range args {
+		switch {
+		case arg == "--json" || arg == "-json":
+			asJSON = true
+		case strings.HasPrefix(arg, "-") || filename != "":
+			return "", false, false
+		default:
+			filename = arg
+		}
+	}
+	return filename, asJSON, filename != ""
+}
+
+func printTokens(out io.Writer, tokens []lexer.Token) {
+	for _, token := range tokens {
+		for _, comment := range token.Comments {
+			fmt.Fprintf(out, "%-12s %-12s %q\n", spanString(lexer.Span{Start: comment.Pos, End: comment.End}), "COMMENT", comment.Text)
+		}
+		fmt.Fprintf(out, "%-12s %-12s %q\n", spanString(token.Span()), token.Type, token.Literal)
+	}
+}
+
+// printNode prints a node on one line with its simple fields, followed by
+// its child nodes, indented and labeled with the field that holds them.
+func printNode(out io.Writer, indent string, label string, node parser.Node) {
+	value := reflect.ValueOf(node)
+	var fields []string
+	var children []func()
+
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i)
+		if name == "Type" || name == "Location" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				item := field.Index(j).Interface()
+				if child, ok := item.(parser.Node); ok {
+					childLabel := fmt.Sprintf("%s[%d]: ", name, j)
+					children = append(children, func() { printNode(out, indent+"  ", childLabel, child) })
+				} else {
+					fields = append(fields, fmt.Sprintf("%s[%d]=%q", name, j, item))
+				}
+			}
+		case reflect.Interface, reflect.Struct:
+			if child, ok := field.Interface().(parser.Node); ok && present(child) {
+				children = append(children, func() { printNode(out, indent+"  ", name+": ", child) })
+			}
+		case reflect.String:
+			fields = append(fields, fmt.Sprintf("%s=%q", name, field.String()))
+		default:
+			fields = append(fields, fmt.Sprintf("%s=%v", name, field.Interface()))
+		}
+	}
+
+	typeName := value.FieldByName("Type").String()
+	fmt.Fprintf(out, "%s%s%s %s", indent, label, typeName, spanString(node.Span()))
+	if len(fields) > 0 {
+		fmt.Fprintf(out, " %s", strings.Join(fields, " "))
+	}
+	fmt.Fprintln(out)
+	for _, child := range children {
+		child()
+	}
+}
+
+// present reports whether a node was parsed from the source. A missing else
+// branch, for one, is an empty ProgramNode with no span.
+func present(node parser.Node) bool {
+	return node.Span() != lexer.Span{}
+}
+
+func spanString(span lexer.Span) string {
+	return fmt.Sprintf("%d:%d-%d:%d", span.Start.Line, span.Start.Column, span.End.Line, span.End.Column)
+}
+
+// The JSON forms use lower camel case keys. Maps are encoded with sorted
+// keys, so the output is stable.
+
+func positionJSON(position lexer.Position) map[string]interface{} {
+	return map[string]interface{}{"offset": position.Offset, "line": position.Line, "column": position.Column}
+}
+
+func spanJSON(span lexer.Span) map[string]interface{} {
+	return map[string]interface{}{"start": positionJSON(span.Start), "end": positionJSON(span.End)}
+}
+
+func tokensJSON(tokens []lexer.Token) []interface{} {
+	list := make([]interface{}, 0, len(tokens))
+	for _, token := range tokens {
+		comments := make([]interface{}, 0, len(token.Comments))
+		for _, comment := range token.Comments {
+			comments = append(comments, map[string]interface{}{
+				"text":     comment.Text,
+				"span":     spanJSON(lexer.Span{Start: comment.Pos, End: comment.End}),
+				"trailing": comment.Trailing,
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"type":     token.Type,
+			"literal":  token.Literal,
+			"span":     spanJSON(token.Span()),
+			"comments": comments,
+		})
+	}
+	return list
+}
+
+func diagnosticsJSON(diagnostics []lexer.Diagnostic) []interface{} {
+	list := make([]interface{}, 0, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		list = append(list, map[string]interface{}{
+			"message":  diagnostic.Message,
+			"severity": diagnostic.Severity,
+			"span":     spanJSON(diagnostic.Location),
+		})
+	}
+	return list
+}
+
+// nodeJSON converts a node to a map with a key for each of its fields.
+func nodeJSON(node parser.Node) map[string]interface{} {
+	value := reflect.ValueOf(node)
+	object := map[string]interface{}{"span": spanJSON(node.Span())}
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if name == "Location" {
+			continue
+		}
+		object[lowerCamel(name)] = valueJSON(value.Field(i))
+	}
+	return object
+}
+
+func valueJSON(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Slice:
+		list := make([]interface{}, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			list = append(list, valueJSON(value.Index(i)))
+		}
+		return list
+	case reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return valueJSON(value.Elem())
+	}
+	if node, ok := value.Interface().(parser.Node); ok {
+		if !present(node) {
+			return nil
+		}
+		return nodeJSON(node)
+	}
+	return value.Interface()
+}
+
+func lowerCamel(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
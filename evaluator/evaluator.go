@@ -3,25 +3,151 @@ package evaluator
 import (
 	"bufio"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"terminascript/lexer"
 	"terminascript/parser"
 )
 
+// Array is the value of an array. Arrays are shared rather than copied when
+// they are assigned or passed to a function.
+type Array struct {
+	Elements []interface{}
+}
+
+// Map is the value of a map. It remembers the order its keys were added in.
+// Like arrays, maps are shared rather than copied.
+type Map struct {
+	Keys   []interface{}
+	Values map[interface{}]interface{}
+}
+
+func NewMap() *Map {
+	return &Map{Values: make(map[interface{}]interface{})}
+}
+
+// Get returns the value for key and whether it is in the map.
+func (m *Map) Get(key interface{}) (interface{}, bool) {
+	value, ok := m.Values[key]
+	return value, ok
+}
+
+// Set adds or replaces the value for key. A new key goes last.
+func (m *Map) Set(key interface{}, value interface{}) {
+	if _, ok := m.Values[key]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+	m.Values[key] = value
+}
+
+// Delete removes key from the map, reporting whether it was there.
+func (m *Map) Delete(key interface{}) bool {
+	if _, ok := m.Values[key]; !ok {
+		return false
+	}
+	delete(m.Values, key)
+	for i, k := range m.Keys {
+		if k == key {
+			m.Keys = append(m.Keys[:i], m.Keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Function is the value of a function. It keeps the environment it was
+// defined in, so its body can use the variables around it even after the
+// function has been returned or passed elsewhere.
+type Function struct {
+	Name       string
+	Parameters []string
+	Body       parser.Node
+	Env        *Environment
+}
+
+func newFunction(name string, parameters []parser.Expression, body parser.Node, e *Environment) *Function {
+	names := make([]string, 0, len(parameters))
+	for _, parameter := range parameters {
+		names = append(names, parameter.(parser.VarAccessNode).Identifier)
+	}
+	return &Function{name, names, body, e}
+}
+
+// Environment holds the variables of a scope. The program has one, and each
+// function call and each run of a block gets a new one inside the scope it
+// belongs to. Names that are not found in a scope are looked up in Outer.
 type Environment struct {
 	Variables map[string]interface{}
-	Functions map[string]parser.FunctionDefenitionNode
+	Outer     *Environment
+}
+
+// RuntimeError is raised when a program fails while it is being evaluated.
+type RuntimeError struct {
+	Message  string
+	Location lexer.Span
+}
+
+func (err RuntimeError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Location, err.Message)
+}
+
+func runtimeError(location lexer.Span, format string, a ...interface{}) {
+	panic(RuntimeError{fmt.Sprintf(format, a...), location})
 }
 
 func NewEnvironment() *Environment {
-	return &Environment{
-		Variables: make(map[string]interface{}),
-		Functions: make(map[string]parser.FunctionDefenitionNode),
+	return &Environment{Variables: make(map[string]interface{})}
+}
+
+// NewEnclosedEnvironment returns an empty scope inside outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	return &Environment{Variables: make(map[string]interface{}), Outer: outer}
+}
+
+// Get returns the value of the variable name in the innermost scope that has
+// it, and whether there is one.
+func (e *Environment) Get(name string) (interface{}, bool) {
+	for scope := e; scope != nil; scope = scope.Outer {
+		if value, ok := scope.Variables[name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Assign sets the variable name in the innermost scope that has it, or
+// defines it in e if none does.
+func (e *Environment) Assign(name string, value interface{}) {
+	for scope := e; scope != nil; scope = scope.Outer {
+		if _, ok := scope.Variables[name]; ok {
+			scope.Variables[name] = value
+			return
+		}
 	}
+	e.Variables[name] = value
 }
 
-func Eval(node interface{}, e *Environment) interface{} {
+// Run evaluates a program, returning a runtime error rather than panicking.
+func Run(node parser.Node, e *Environment) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeErr, ok := r.(RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = runtimeErr
+		}
+	}()
+	result = Eval(node, e)
+	if returned, ok := result.(ReturnValue); ok {
+		result = returned.Value
+	}
+	return result, nil
+}
+
+func Eval(node parser.Node, e *Environment) interface{} {
 	switch n := node.(type) {
 	case parser.ProgramNode:
 		return parseProgramNode(n, e)
@@ -29,170 +155,639 @@ func Eval(node interface{}, e *Environment) interface{} {
 		return parseBinOpNode(n, e)
 	case parser.UnaryOpNode:
 		return parseUnaryOpNode(n, e)
+	case parser.ConditionalNode:
+		return parseConditionalNode(n, e)
 	case parser.AssignmentNode:
 		return parseAssignNode(n, e)
+	case parser.UpdateNode:
+		return parseUpdateNode(n, e)
+	case parser.ReturnNode:
+		return parseReturnNode(n, e)
 	case parser.IfNode:
 		return parseIfNode(n, e)
 	case parser.WhileNode:
 		return parseWhileNode(n, e)
 	case parser.ForNode:
 		return parseForNode(n, e)
+	case parser.ForInNode:
+		return parseForInNode(n, e)
+	case parser.BreakNode:
+		return BreakValue{n.Label}
+	case parser.ContinueNode:
+		return ContinueValue{n.Label}
 	case parser.VarAccessNode:
-		return e.Variables[n.Identifier]
+		return parseVarAccessNode(n, e)
 	case parser.FunctionCallNode:
 		return parseFunctionCallNode(n, e)
 	case parser.FunctionDefenitionNode:
 		return parseFunctionDefenitionNode(n, e)
+	case parser.FunctionNode:
+		return newFunction("", n.Parameters, n.Consequence, e)
+	case parser.ArrowFunctionNode:
+		return newFunction("", n.Parameters, n.Body, e)
 	case parser.IntNode:
 		return n.Value
+	case parser.FloatNode:
+		return n.Value
 	case parser.StringNode:
 		return n.Value
+	case parser.InterpolationNode:
+		return parseInterpolationNode(n, e)
+	case parser.ArrayNode:
+		return parseArrayNode(n, e)
+	case parser.IndexNode:
+		return parseIndexNode(n, e)
+	case parser.SliceNode:
+		return parseSliceNode(n, e)
+	case parser.MapNode:
+		return parseMapNode(n, e)
+	case parser.MemberNode:
+		return parseMemberNode(n, e)
 	}
+	runtimeError(node.Span(), "cannot evaluate %s", node)
 	return -1
 }
 
+// parseProgramNode runs a block of statements, stopping early if one of
+// them returns, breaks or continues.
 func parseProgramNode(n parser.ProgramNode, e *Environment) interface{} {
 	for _, node := range n.Expressions {
-		switch n := node.(type) {
-		case parser.ReturnNode:
-			return n
+		returned := Eval(node, e)
+		if isJump(returned) {
+			return returned
 		}
-		Eval(node, e)
 	}
 	return -1
 }
 
+func parseReturnNode(n parser.ReturnNode, e *Environment) ReturnValue {
+	if n.Expression == nil {
+		return ReturnValue{-1}
+	}
+	return ReturnValue{Eval(n.Expression, e)}
+}
+
+// parseForNode evaluates the bounds and step once, then counts up or down
+// depending on the sign of the step.
 func parseForNode(n parser.ForNode, e *Environment) interface{} {
-	for i := Eval(n.MinValue, e).(int); i < Eval(n.MaxValue, e).(int); i++ {
-		var returned interface{}
-		e.Variables[n.Identifier] = i
-		for _, node := range n.Consequence.Expressions {
-			returned = Eval(node, e)
-
-			if isReturn(returned) {
-				return returned
-			} else if isReturn(node) {
-				return node
-			}
+	min := forBound(n.MinValue, e)
+	max := forBound(n.MaxValue, e)
+	step := 1
+	if n.Step != nil {
+		step = forBound(n.Step, e)
+		if step == 0 {
+			runtimeError(n.Step.Span(), "for loop step cannot be 0")
 		}
 	}
-	return -1
-}
 
-func parseWhileNode(n parser.WhileNode, e *Environment) interface{} {
-	for parseConditions(n.Condition, e) {
-		var returned interface{}
-		for _, node := range n.Consequence.Expressions {
-			returned = Eval(node, e)
-
-			if isReturn(returned) {
-				return returned
-			} else if isReturn(node) {
-				return node
-			}
+	for i := min; inRange(i, max, step, n.Inclusive); i += step {
+		scope := NewEnclosedEnvironment(e)
+		scope.Variables[n.Identifier] = i
+		returned := parseProgramNode(n.Consequence, scope)
+		if exit, result := leavesLoop(n.Label, returned); exit {
+			return result
 		}
 	}
 	return -1
 }
 
-func parseIfNode(n parser.IfNode, e *Environment) interface{} {
-	if parseConditions(n.Condition, e) {
-		for _, node := range n.Consequence.Expressions {
-			returned := Eval(node, e)
+// parseForInNode runs the body for each element of the iterable.
+func parseForInNode(n parser.ForInNode, e *Environment) interface{} {
+	var result interface{} = -1
+	iterate(n.Iterable, e, n.Key == "", func(key interface{}, value interface{}) bool {
+		scope := NewEnclosedEnvironment(e)
+		if n.Key != "" {
+			scope.Variables[n.Key] = key
+		}
+		scope.Variables[n.Value] = value
+		returned := parseProgramNode(n.Consequence, scope)
+		exit, loopResult := leavesLoop(n.Label, returned)
+		if exit {
+			result = loopResult
+		}
+		return !exit
+	})
+	return result
+}
 
-			if isReturn(returned) {
-				return returned
+// iterate calls f with the key and value of each element of iterable until
+// f returns false. The elements of a string are its characters, keyed by
+// their index, and the elements of a range are its ints. A map gives its keys
+// and values in order, or its keys by position if keysOnly is set.
+func iterate(iterable parser.Expression, e *Environment, keysOnly bool, f func(key interface{}, value interface{}) bool) {
+	if r, ok := iterable.(parser.RangeNode); ok {
+		start := forBound(r.Start, e)
+		end := forBound(r.End, e)
+		step := 1
+		if r.Step != nil {
+			step = forBound(r.Step, e)
+			if step == 0 {
+				runtimeError(r.Step.Span(), "for loop step cannot be 0")
 			}
-			if isReturn(node) {
-				return node
+		}
+		for i, index := start, 0; inRange(i, end, step, r.Inclusive); i, index = i+step, index+1 {
+			if !f(index, i) {
+				return
 			}
 		}
-	} else {
-		for _, node := range n.Alternate.Expressions {
-			returned := Eval(node, e)
+		return
+	}
 
-			if isReturn(returned) {
-				return returned
-			} else if isReturn(node) {
-				return node
+	value := Eval(iterable, e)
+	switch v := value.(type) {
+	case *Array:
+		// The loop goes over the elements the array had when it started, so
+		// elements added or removed during the loop do not change it.
+		for i, element := range append([]interface{}{}, v.Elements...) {
+			if !f(i, element) {
+				return
+			}
+		}
+	case *Map:
+		// Keys added during the loop are not visited, and keys deleted
+		// before they are reached are skipped.
+		for i, key := range append([]interface{}{}, v.Keys...) {
+			value, ok := v.Get(key)
+			if !ok {
+				continue
+			}
+			if keysOnly {
+				value = key
+				key = i
+			}
+			if !f(key, value) {
+				return
 			}
 		}
+	case string:
+		index := 0
+		for _, ch := range v {
+			if !f(index, string(ch)) {
+				return
+			}
+			index++
+		}
+	default:
+		runtimeError(iterable.Span(), "cannot iterate over %s", typeName(value))
 	}
-	return -1
 }
 
-func parseConditions(conditions []parser.ConditionNode, e *Environment) bool {
-	result := true
-	for _, condition := range conditions {
-		evaluated := toBool(Eval(condition.Condition, e).(int))
-		switch condition.Seperator {
-		case lexer.AND:
-			result = result && evaluated
-		case lexer.OR:
-			result = result || evaluated
+func forBound(n parser.Expression, e *Environment) int {
+	value := Eval(n, e)
+	bound, ok := value.(int)
+	if !ok {
+		runtimeError(n.Span(), "for loop bounds and step must be ints, not %s", typeName(value))
+	}
+	return bound
+}
+
+func inRange(i int, max int, step int, inclusive bool) bool {
+	switch {
+	case step > 0 && inclusive:
+		return i <= max
+	case step > 0:
+		return i < max
+	case inclusive:
+		return i >= max
+	}
+	return i > max
+}
+
+func parseWhileNode(n parser.WhileNode, e *Environment) interface{} {
+	for truthy(Eval(n.Condition, e)) {
+		returned := parseProgramNode(n.Consequence, NewEnclosedEnvironment(e))
+		if exit, result := leavesLoop(n.Label, returned); exit {
+			return result
 		}
 	}
+	return -1
+}
 
-	return result
+// parseIfNode runs the first branch whose conditions hold, or the else
+// branch if none do.
+func parseIfNode(n parser.IfNode, e *Environment) interface{} {
+	for _, branch := range n.Cases {
+		if truthy(Eval(branch.Condition, e)) {
+			return parseProgramNode(branch.Consequence, NewEnclosedEnvironment(e))
+		}
+	}
+	return parseProgramNode(n.Alternate, NewEnclosedEnvironment(e))
 }
 
+// parseAssignNode assigns to a variable or an element. A declaration
+// defines the variable in the current scope, while an assignment changes it
+// in the scope it was defined in. A compound assignment such as "x += 1"
+// applies its operator to the current value first. The target is evaluated
+// before the value.
 func parseAssignNode(n parser.AssignmentNode, e *Environment) interface{} {
-	value := Eval(n.Value, e)
-	e.Variables[n.Identifier] = value
+	var value interface{} = 0
+	if n.Declaration {
+		if n.Value != nil {
+			value = Eval(n.Value, e)
+		}
+		e.Variables[n.Target.(parser.VarAccessNode).Identifier] = value
+		return value
+	}
+
+	ref := resolve(n.Target, e)
+	if n.Value != nil {
+		value = Eval(n.Value, e)
+	}
+	if n.Op != "" {
+		value = binaryOperation(n.Op, ref.get(), value, n.Location)
+	}
+	ref.set(value)
 	return value
 }
 
-func parseUnaryOpNode(n parser.UnaryOpNode, e *Environment) int {
+// parseUpdateNode adds or subtracts 1. It evaluates to the new value when
+// written before the target, and to the old value when written after it.
+func parseUpdateNode(n parser.UpdateNode, e *Environment) interface{} {
+	ref := resolve(n.Target, e)
+	old := ref.get()
+	value := binaryOperation(n.Op, old, 1, n.Location)
+	ref.set(value)
+	if n.Prefix {
+		return value
+	}
+	return old
+}
+
+// reference is a place that can be assigned to, such as a variable or an
+// array element.
+type reference struct {
+	get func() interface{}
+	set func(value interface{})
+}
+
+// resolve evaluates the parts of an assignment target, such as the array and
+// index of "xs[i]", once.
+func resolve(target parser.Expression, e *Environment) reference {
+	switch t := target.(type) {
+	case parser.VarAccessNode:
+		return reference{
+			get: func() interface{} { return parseVarAccessNode(t, e) },
+			set: func(value interface{}) { e.Assign(t.Identifier, value) },
+		}
+	case parser.IndexNode:
+		left := Eval(t.Left, e)
+		switch l := left.(type) {
+		case *Array:
+			// The value may change the length of the array, so the index is
+			// checked again each time it is used.
+			index := Eval(t.Index, e)
+			arrayIndex(t, l, index)
+			return reference{
+				get: func() interface{} { return l.Elements[arrayIndex(t, l, index)] },
+				set: func(value interface{}) { l.Elements[arrayIndex(t, l, index)] = value },
+			}
+		case *Map:
+			return mapReference(t.Location, l, mapKey(t.Index.Span(), Eval(t.Index, e)))
+		}
+		runtimeError(t.Location, "cannot assign to an element of %s", typeName(left))
+	case parser.MemberNode:
+		return mapReference(t.Location, memberMap(t, e), t.Name)
+	}
+	runtimeError(target.Span(), "cannot assign to %s", target)
+	return reference{}
+}
+
+func mapReference(location lexer.Span, m *Map, key interface{}) reference {
+	return reference{
+		get: func() interface{} { return mapGet(location, m, key) },
+		set: func(value interface{}) { m.Set(key, value) },
+	}
+}
+
+func parseVarAccessNode(n parser.VarAccessNode, e *Environment) interface{} {
+	value, ok := e.Get(n.Identifier)
+	if !ok {
+		runtimeError(n.Location, "undefined variable %s", n.Identifier)
+	}
+	return value
+}
+
+func parseMapNode(n parser.MapNode, e *Environment) *Map {
+	m := NewMap()
+	for i, key := range n.Keys {
+		k := mapKey(key.Span(), Eval(key, e))
+		m.Set(k, Eval(n.Values[i], e))
+	}
+	return m
+}
+
+func parseMemberNode(n parser.MemberNode, e *Environment) interface{} {
+	return mapGet(n.Location, memberMap(n, e), n.Name)
+}
+
+func memberMap(n parser.MemberNode, e *Environment) *Map {
+	left := Eval(n.Left, e)
+	m, ok := left.(*Map)
+	if !ok {
+		runtimeError(n.Location, "cannot get .%s of %s", n.Name, typeName(left))
+	}
+	return m
+}
+
+func mapGet(location lexer.Span, m *Map, key interface{}) interface{} {
+	value, ok := m.Get(key)
+	if !ok {
+		runtimeError(location, "key %s not found in map", valueString(key, true, nil))
+	}
+	return value
+}
+
+// mapKey checks that a value can be used as a map key. Only strings and
+// numbers can, and a float with an int value is the same key as that int.
+func mapKey(location lexer.Span, key interface{}) interface{} {
+	switch k := key.(type) {
+	case string, int:
+		return k
+	case float64:
+		if k == math.Trunc(k) && math.Abs(k) < 1<<53 {
+			return int(k)
+		}
+		return k
+	}
+	runtimeError(location, "%s cannot be used as a map key", typeName(key))
+	return nil
+}
+
+func parseArrayNode(n parser.ArrayNode, e *Environment) *Array {
+	elements := make([]interface{}, 0, len(n.Elements))
+	for _, element := range n.Elements {
+		elements = append(elements, Eval(element, e))
+	}
+	return &Array{elements}
+}
+
+// parseIndexNode reads an element of an array, a character of a string or
+// the value for a key of a map. Negative indices count from the end.
+func parseIndexNode(n parser.IndexNode, e *Environment) interface{} {
+	left := Eval(n.Left, e)
+	index := Eval(n.Index, e)
+	switch l := left.(type) {
+	case *Array:
+		return l.Elements[arrayIndex(n, l, index)]
+	case *Map:
+		return mapGet(n.Location, l, mapKey(n.Index.Span(), index))
+	case string:
+		chars := []rune(l)
+		return string(chars[checkIndex(n.Location, index, len(chars), "string")])
+	}
+	runtimeError(n.Location, "cannot index %s", typeName(left))
+	return -1
+}
+
+func arrayIndex(n parser.IndexNode, array *Array, index interface{}) int {
+	return checkIndex(n.Location, index, len(array.Elements), "array")
+}
+
+// checkIndex turns an index into a collection of length into a position,
+// counting negative indices from the end.
+func checkIndex(location lexer.Span, index interface{}, length int, kind string) int {
+	i, ok := index.(int)
+	if !ok {
+		runtimeError(location, "%s index must be an int, not %s", kind, typeName(index))
+	}
+	position := i
+	if position < 0 {
+		position += length
+	}
+	if position < 0 || position >= length {
+		runtimeError(location, "index %d out of range for %s of length %d", i, kind, length)
+	}
+	return position
+}
+
+// parseSliceNode copies part of an array or string. Start defaults to the
+// beginning and End to the end, and negative bounds count from the end.
+func parseSliceNode(n parser.SliceNode, e *Environment) interface{} {
+	left := Eval(n.Left, e)
+	switch l := left.(type) {
+	case *Array:
+		start, end := sliceBounds(n, e, len(l.Elements), "array")
+		return &Array{append([]interface{}{}, l.Elements[start:end]...)}
+	case string:
+		chars := []rune(l)
+		start, end := sliceBounds(n, e, len(chars), "string")
+		return string(chars[start:end])
+	}
+	runtimeError(n.Location, "cannot slice %s", typeName(left))
+	return -1
+}
+
+func sliceBounds(n parser.SliceNode, e *Environment, length int, kind string) (int, int) {
+	start, end := 0, length
+	if n.Start != nil {
+		start = sliceBound(n.Start, e, length, kind)
+	}
+	if n.End != nil {
+		end = sliceBound(n.End, e, length, kind)
+	}
+	if start > end {
+		runtimeError(n.Location, "slice start %d is after its end %d", start, end)
+	}
+	return start, end
+}
+
+func sliceBound(n parser.Expression, e *Environment, length int, kind string) int {
+	value := Eval(n, e)
+	bound, ok := value.(int)
+	if !ok {
+		runtimeError(n.Span(), "slice bounds must be ints, not %s", typeName(value))
+	}
+	position := bound
+	if position < 0 {
+		position += length
+	}
+	if position < 0 || position > length {
+		runtimeError(n.Span(), "slice bound %d out of range for %s of length %d", bound, kind, length)
+	}
+	return position
+}
+
+func parseUnaryOpNode(n parser.UnaryOpNode, e *Environment) interface{} {
+	right := Eval(n.Right, e)
 	switch n.Op {
 	case lexer.SUB:
-		return -Eval(n.Right, e).(int)
-	case lexer.NOT:
-		if Eval(n.Right, e).(int) == 1 {
-			return 0
-		} else {
-			return 1
+		switch value := right.(type) {
+		case int:
+			return -value
+		case float64:
+			return -value
 		}
+		runtimeError(n.Location, "bad operand type for unary -: %s", typeName(right))
+	case lexer.NOT:
+		return toBinary(!truthy(right))
 	}
 
 	return -1
 }
 
-func parseBinOpNode(n parser.BinaryOperationNode, e *Environment) int {
+// parseConditionalNode evaluates only the branch the condition selects.
+func parseConditionalNode(n parser.ConditionalNode, e *Environment) interface{} {
+	if truthy(Eval(n.Condition, e)) {
+		return Eval(n.Consequence, e)
+	}
+	return Eval(n.Alternate, e)
+}
+
+func parseBinOpNode(n parser.BinaryOperationNode, e *Environment) interface{} {
+	left := Eval(n.Left, e)
+
+	// && and || only evaluate their right operand if the left one does not
+	// already decide the result.
 	switch n.Op {
+	case lexer.AND:
+		return toBinary(truthy(left) && truthy(Eval(n.Right, e)))
+	case lexer.OR:
+		return toBinary(truthy(left) || truthy(Eval(n.Right, e)))
+	}
+
+	return binaryOperation(n.Op, left, Eval(n.Right, e), n.Location)
+}
+
+// binaryOperation applies a comparison or arithmetic operator.
+func binaryOperation(op string, left interface{}, right interface{}, location lexer.Span) interface{} {
+	switch op {
+	case lexer.EE:
+		return toBinary(equals(left, right))
+	case lexer.NE:
+		return toBinary(!equals(left, right))
+	}
+
+	// Arithmetic on two ints stays an int, so / and % truncate. If either
+	// operand is a float the other is promoted and the result is a float.
+	switch l := left.(type) {
+	case int:
+		switch r := right.(type) {
+		case int:
+			return intOperation(op, location, l, r)
+		case float64:
+			return floatOperation(op, float64(l), r)
+		}
+	case float64:
+		switch r := right.(type) {
+		case int:
+			return floatOperation(op, l, float64(r))
+		case float64:
+			return floatOperation(op, l, r)
+		}
+	}
+
+	runtimeError(location, "unsupported operand types for %s: %s and %s", lexer.Symbol(op), typeName(left), typeName(right))
+	return -1
+}
+
+func intOperation(op string, location lexer.Span, left int, right int) interface{} {
+	switch op {
 	case lexer.ADD:
-		return Eval(n.Left, e).(int) + Eval(n.Right, e).(int)
+		return left + right
 	case lexer.SUB:
-		return Eval(n.Left, e).(int) - Eval(n.Right, e).(int)
+		return left - right
 	case lexer.MUL:
-		return Eval(n.Left, e).(int) * Eval(n.Right, e).(int)
+		return left * right
 	case lexer.DIV:
-		return Eval(n.Left, e).(int) / Eval(n.Right, e).(int)
+		if right == 0 {
+			runtimeError(location, "division by zero")
+		}
+		return left / right
 	case lexer.MOD:
-		return Eval(n.Left, e).(int) % Eval(n.Right, e).(int)
+		if right == 0 {
+			runtimeError(location, "division by zero")
+		}
+		return left % right
+
+	case lexer.GT:
+		return toBinary(left > right)
+	case lexer.LT:
+		return toBinary(left < right)
+	case lexer.GTE:
+		return toBinary(left >= right)
+	case lexer.LTE:
+		return toBinary(left <= right)
+	}
+
+	return -1
+}
+
+func floatOperation(op string, left float64, right float64) interface{} {
+	switch op {
+	case lexer.ADD:
+		return left + right
+	case lexer.SUB:
+		return left - right
+	case lexer.MUL:
+		return left * right
+	case lexer.DIV:
+		return left / right
+	case lexer.MOD:
+		return math.Mod(left, right)
 
-	case lexer.EE:
-		return toBinary(Eval(n.Left, e).(int) == Eval(n.Right, e).(int))
-	case lexer.NE:
-		return toBinary(Eval(n.Left, e).(int) != Eval(n.Right, e).(int))
 	case lexer.GT:
-		return toBinary(Eval(n.Left, e).(int) > Eval(n.Right, e).(int))
+		return toBinary(left > right)
 	case lexer.LT:
-		return toBinary(Eval(n.Left, e).(int) < Eval(n.Right, e).(int))
+		return toBinary(left < right)
 	case lexer.GTE:
-		return toBinary(Eval(n.Left, e).(int) >= Eval(n.Right, e).(int))
+		return toBinary(left >= right)
 	case lexer.LTE:
-		return toBinary(Eval(n.Left, e).(int) <= Eval(n.Right, e).(int))
+		return toBinary(left <= right)
 	}
 
 	return -1
 }
 
-func toBool(value int) bool {
-	if value == 1 {
+// equals compares two values, treating an int and a float with the same
+// value as equal.
+func equals(left interface{}, right interface{}) bool {
+	switch l := left.(type) {
+	case int:
+		if r, ok := right.(float64); ok {
+			return float64(l) == r
+		}
+	case float64:
+		if r, ok := right.(int); ok {
+			return l == float64(r)
+		}
+	}
+	return left == right
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case *Array:
+		return len(v.Elements) > 0
+	case *Map:
+		return len(v.Keys) > 0
+	case *Function:
 		return true
-	} else {
-		return false
 	}
+	return false
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case *Array:
+		return "array"
+	case *Map:
+		return "map"
+	case *Function:
+		return "function"
+	}
+	return "unknown"
 }
 
 func toBinary(value bool) int {
@@ -203,45 +798,157 @@ func toBinary(value bool) int {
 	}
 }
 
-func isReturn(node interface{}) bool {
-	switch node.(type) {
-	case parser.ReturnNode:
+// ReturnValue carries the value of a return statement out through the
+// blocks and loops around it to the function call.
+type ReturnValue struct {
+	Value interface{}
+}
+
+func isReturn(value interface{}) bool {
+	_, ok := value.(ReturnValue)
+	return ok
+}
+
+// BreakValue and ContinueValue carry a break or continue statement out to
+// the loop it applies to. An empty Label means the innermost loop.
+type BreakValue struct {
+	Label string
+}
+
+type ContinueValue struct {
+	Label string
+}
+
+// isJump reports whether value interrupts the statements around it.
+func isJump(value interface{}) bool {
+	switch value.(type) {
+	case ReturnValue, BreakValue, ContinueValue:
 		return true
 	}
 	return false
 }
 
+// leavesLoop decides what the loop labeled label does after its body
+// finished with value. It reports whether the loop stops, and if so what the
+// loop evaluates to: a jump aimed at an outer loop or function is passed on.
+func leavesLoop(label string, value interface{}) (bool, interface{}) {
+	switch v := value.(type) {
+	case BreakValue:
+		if v.Label == "" || v.Label == label {
+			return true, -1
+		}
+		return true, v
+	case ContinueValue:
+		if v.Label == "" || v.Label == label {
+			return false, nil
+		}
+		return true, v
+	case ReturnValue:
+		return true, v
+	}
+	return false, nil
+}
+
 func parseFunctionDefenitionNode(n parser.FunctionDefenitionNode, e *Environment) interface{} {
-	e.Functions[n.Identifier] = n
-	return n.Identifier
+	function := newFunction(n.Identifier, n.Parameters, n.Consequence, e)
+	e.Variables[n.Identifier] = function
+	return function
 }
 
+// parseFunctionCallNode calls the function that the callee evaluates to, or
+// a builtin if the callee is a builtin's name and nothing in scope has that
+// name.
 func parseFunctionCallNode(n parser.FunctionCallNode, e *Environment) interface{} {
-	switch n.Identifier {
+	name := functionName(n)
+	if _, bound := e.Get(name); bound {
+		return handleCustomFunction(n, e)
+	}
+
+	switch name {
 	case "print":
 		return handlePrint(n, e)
 	case "input":
 		return handleInput(n, e)
+	case "len":
+		return handleLen(n, e)
+	case "push":
+		return handlePush(n, e)
+	case "pop":
+		return handlePop(n, e)
+	case "keys":
+		return handleKeys(n, e)
+	case "values":
+		return handleValues(n, e)
+	case "has":
+		return handleHas(n, e)
+	case "delete":
+		return handleDelete(n, e)
 	default:
 		return handleCustomFunction(n, e)
 	}
 }
 
+// functionName returns the name a function is called by, or "" if the
+// callee is not a name.
+func functionName(n parser.FunctionCallNode) string {
+	if name, ok := n.Function.(parser.VarAccessNode); ok {
+		return name.Identifier
+	}
+	return ""
+}
+
 func handleCustomFunction(n parser.FunctionCallNode, e *Environment) interface{} {
-	if function, ok := e.Functions[n.Identifier]; ok {
-		localScope := &Environment{make(map[string]interface{}), make(map[string]parser.FunctionDefenitionNode)}
-		for i, parameter := range function.Parameters {
-			localScope.Variables[parameter.(parser.VarAccessNode).Identifier] = Eval(n.Parameters[i], e)
+	if name := functionName(n); name != "" {
+		if _, defined := e.Get(name); !defined {
+			runtimeError(n.Location, "undefined function %s", name)
 		}
+	}
+	value := Eval(n.Function, e)
+	function, ok := value.(*Function)
+	if !ok {
+		runtimeError(n.Location, "cannot call %s", typeName(value))
+	}
 
-		returned := Eval(function.Consequence, localScope)
-		if isReturn(returned) {
-			return Eval(returned.(parser.ReturnNode).Expression, e)
-		} else {
-			return returned
-		}
+	values := make([]interface{}, 0, len(n.Parameters))
+	for _, parameter := range n.Parameters {
+		values = append(values, Eval(parameter, e))
+	}
+	return callFunction(function, values, n.Location)
+}
+
+// maxCallDepth limits how deeply function calls can nest, so that runaway
+// recursion is a runtime error rather than a crash of the interpreter.
+const maxCallDepth = 10000
+
+// callDepth is the number of function calls currently running.
+var callDepth int
+
+// callFunction runs the body of a function in a new scope inside the one it
+// was defined in, with its parameters set to values.
+func callFunction(function *Function, values []interface{}, location lexer.Span) interface{} {
+	name := function.Name
+	if name == "" {
+		name = "function"
+	}
+	checkArgumentCount(location, name, len(function.Parameters), len(values))
+
+	if callDepth >= maxCallDepth {
+		runtimeError(location, "maximum call depth exceeded")
+	}
+	callDepth++
+	defer func() { callDepth-- }()
+
+	scope := NewEnclosedEnvironment(function.Env)
+	for i, parameter := range function.Parameters {
+		scope.Variables[parameter] = values[i]
+	}
+
+	returned := Eval(function.Body, scope)
+	if isReturn(returned) {
+		return returned.(ReturnValue).Value
+	} else {
+		return returned
 	}
-	return -1
 }
 
 func handleInput(n parser.FunctionCallNode, e *Environment) string {
@@ -262,19 +969,183 @@ func handlePrint(n parser.FunctionCallNode, e *Environment) string {
 	return str
 }
 
+// arguments evaluates the arguments of a builtin that takes count of them.
+func arguments(n parser.FunctionCallNode, e *Environment, count int) []interface{} {
+	checkArgumentCount(n.Location, functionName(n), count, len(n.Parameters))
+	values := make([]interface{}, 0, count)
+	for _, parameter := range n.Parameters {
+		values = append(values, Eval(parameter, e))
+	}
+	return values
+}
+
+func checkArgumentCount(location lexer.Span, name string, count int, got int) {
+	if got != count {
+		plural := "s"
+		if count == 1 {
+			plural = ""
+		}
+		runtimeError(location, "%s expects %d argument%s, got %d", name, count, plural, got)
+	}
+}
+
+func arrayArgument(n parser.FunctionCallNode, value interface{}) *Array {
+	array, ok := value.(*Array)
+	if !ok {
+		runtimeError(n.Location, "%s expects an array, not %s", functionName(n), typeName(value))
+	}
+	return array
+}
+
+// handleLen returns the number of elements of an array or map, or of
+// characters of a string.
+func handleLen(n parser.FunctionCallNode, e *Environment) int {
+	switch value := arguments(n, e, 1)[0].(type) {
+	case *Array:
+		return len(value.Elements)
+	case *Map:
+		return len(value.Keys)
+	case string:
+		return len([]rune(value))
+	default:
+		runtimeError(n.Location, "len expects an array, map or string, not %s", typeName(value))
+	}
+	return -1
+}
+
+// handlePush appends a value to an array and returns its new length.
+func handlePush(n parser.FunctionCallNode, e *Environment) int {
+	values := arguments(n, e, 2)
+	array := arrayArgument(n, values[0])
+	array.Elements = append(array.Elements, values[1])
+	return len(array.Elements)
+}
+
+// handlePop removes the last element of an array and returns it.
+func handlePop(n parser.FunctionCallNode, e *Environment) interface{} {
+	array := arrayArgument(n, arguments(n, e, 1)[0])
+	if len(array.Elements) == 0 {
+		runtimeError(n.Location, "pop from an empty array")
+	}
+	last := array.Elements[len(array.Elements)-1]
+	array.Elements = array.Elements[:len(array.Elements)-1]
+	return last
+}
+
+func mapArgument(n parser.FunctionCallNode, value interface{}) *Map {
+	m, ok := value.(*Map)
+	if !ok {
+		runtimeError(n.Location, "%s expects a map, not %s", functionName(n), typeName(value))
+	}
+	return m
+}
+
+// handleKeys returns the keys of a map as an array, in order.
+func handleKeys(n parser.FunctionCallNode, e *Environment) *Array {
+	m := mapArgument(n, arguments(n, e, 1)[0])
+	return &Array{append([]interface{}{}, m.Keys...)}
+}
+
+// handleValues returns the values of a map as an array, in key order.
+func handleValues(n parser.FunctionCallNode, e *Environment) *Array {
+	m := mapArgument(n, arguments(n, e, 1)[0])
+	values := make([]interface{}, 0, len(m.Keys))
+	for _, key := range m.Keys {
+		values = append(values, m.Values[key])
+	}
+	return &Array{values}
+}
+
+// handleHas reports whether a map has a key.
+func handleHas(n parser.FunctionCallNode, e *Environment) int {
+	values := arguments(n, e, 2)
+	_, ok := mapArgument(n, values[0]).Get(mapKey(n.Parameters[1].Span(), values[1]))
+	return toBinary(ok)
+}
+
+// handleDelete removes a key from a map, reporting whether it was there.
+func handleDelete(n parser.FunctionCallNode, e *Environment) int {
+	values := arguments(n, e, 2)
+	return toBinary(mapArgument(n, values[0]).Delete(mapKey(n.Parameters[1].Span(), values[1])))
+}
+
 func paramsToString(n parser.FunctionCallNode, e *Environment) string {
 	str := ""
 	for i, param := range n.Parameters {
 		if i != 0 {
 			str += " "
 		}
-		result := Eval(param, e)
-		switch res := result.(type) {
-		case int:
-			str += strconv.Itoa(res)
-		case string:
-			str += res
-		}
+		str += toString(Eval(param, e))
 	}
 	return str
 }
+
+func parseInterpolationNode(n parser.InterpolationNode, e *Environment) string {
+	var str strings.Builder
+	for i, expression := range n.Expressions {
+		str.WriteString(n.Strings[i])
+		str.WriteString(toString(Eval(expression, e)))
+	}
+	str.WriteString(n.Strings[len(n.Strings)-1])
+	return str.String()
+}
+
+func toString(value interface{}) string {
+	return valueString(value, false, make(map[interface{}]bool))
+}
+
+// valueString formats a value, quoting it if it is a string inside an array
+// or map. seen holds the arrays and maps being formatted, so one that
+// contains itself is printed as [...] or {...} there.
+func valueString(value interface{}, quoted bool, seen map[interface{}]bool) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return formatFloat(v)
+	case string:
+		if quoted {
+			return strconv.Quote(v)
+		}
+		return v
+	case *Array:
+		if seen[v] {
+			return "[...]"
+		}
+		seen[v] = true
+		defer delete(seen, v)
+
+		elements := make([]string, 0, len(v.Elements))
+		for _, element := range v.Elements {
+			elements = append(elements, valueString(element, true, seen))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *Map:
+		if seen[v] {
+			return "{...}"
+		}
+		seen[v] = true
+		defer delete(seen, v)
+
+		entries := make([]string, 0, len(v.Keys))
+		for _, key := range v.Keys {
+			entries = append(entries, valueString(key, true, seen)+": "+valueString(v.Values[key], true, seen))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case *Function:
+		if v.Name == "" {
+			return "<func>"
+		}
+		return "<func " + v.Name + ">"
+	}
+	return ""
+}
+
+// formatFloat prints a float so that it never reads back as an int.
+func formatFloat(value float64) string {
+	str := strconv.FormatFloat(value, 'g', -1, 64)
+	if strings.ContainsAny(str, ".eIN") {
+		return str
+	}
+	return str + ".0"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"terminascript/format"
+)
+
+// formatCommand runs "terminascript fmt", which prints each file in the
+// canonical style, or rewrites it with -w, or shows what would change with
+// -d. With no files it formats standard input. It returns the exit status.
+func formatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the result to the file instead of standard output")
+	diff := flags.Bool("d", false, "print a diff instead of the formatted source")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: terminascript fmt [-w] [-d] [file ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w with standard input")
+			return 2
+		}
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !formatSource("<standard input>", string(source), false, *diff) {
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		if !formatSource(filename, string(source), *write, *diff) {
+			status = 1
+		}
+	}
+	return status
+}
+
+// formatSource formats one file, reporting whether that succeeded.
+func formatSource(filename string, source string, write bool, diff bool) bool {
+	formatted, diagnostics := format.Source(filename, source)
+	if len(diagnostics) > 0 {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+		return false
+	}
+
+	if diff {
+		fmt.Print(format.Diff(filename+".orig", filename, source, formatted))
+	}
+	if write && formatted != source {
+		info, err := os.Stat(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+		if err := os.WriteFile(filename, []byte(formatted), info.Mode().Perm()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+	}
+	if !write && !diff {
+		fmt.Print(formatted)
+	}
+	return true
+}
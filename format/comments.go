@@ -0,0 +1,71 @@
+package format
+
+import (
+	"fmt"
+	"terminascript/lexer"
+	"terminascript/parser"
+)
+
+// placedComment is a comment along with the number of tokens before it,
+// leaving out the tokens that formatting may add or remove.
+type placedComment struct {
+	tokens int
+	text   string
+}
+
+// placeComments lists the comments of a program in order, each with the
+// position in the program that it was written at.
+func placeComments(tokens []lexer.Token) []placedComment {
+	var placed []placedComment
+	count := 0
+	for _, token := range tokens {
+		for _, comment := range token.Comments {
+			placed = append(placed, placedComment{count, commentText(comment)})
+		}
+		switch token.Type {
+		case lexer.LPAREN, lexer.RPAREN, lexer.COMMA, lexer.SEMICOLON:
+		default:
+			count++
+		}
+	}
+	return placed
+}
+
+// checkFormatted checks that the formatted source is the same program as
+// the original and has every comment between the same tokens. Parentheses,
+// commas and semicolons are not counted, since the formatter adds and
+// removes them. If formatting changed anything, it returns a diagnostic, so
+// that a mistake in the formatter is reported rather than written out.
+func checkFormatted(filename string, tokens []lexer.Token, program parser.ProgramNode, formatted string) (lexer.Diagnostic, bool) {
+	start := lexer.Position{Filename: filename, Line: 1, Column: 1}
+	fileStart := lexer.Span{Start: start, End: start}
+
+	formattedTokens, diagnostics := lexer.NewLexer(filename, formatted).Lex()
+	if len(diagnostics) == 0 {
+		var formattedProgram parser.ProgramNode
+		formattedProgram, diagnostics = parser.NewParser(formattedTokens).Parse()
+		if len(diagnostics) == 0 && formattedProgram.String() != program.String() {
+			return lexer.Diagnostic{Message: "formatting would change the program", Location: fileStart, Severity: lexer.ERROR}, true
+		}
+	}
+	if len(diagnostics) > 0 {
+		message := fmt.Sprintf("formatting produced invalid code: %s", diagnostics[0].Message)
+		return lexer.Diagnostic{Message: message, Location: fileStart, Severity: lexer.ERROR}, true
+	}
+
+	before, after := placeComments(tokens), placeComments(formattedTokens)
+	var comments []lexer.Comment
+	for _, token := range tokens {
+		comments = append(comments, token.Comments...)
+	}
+	for i, comment := range before {
+		if i >= len(after) || after[i] != comment {
+			location := lexer.Span{Start: comments[i].Pos, End: comments[i].End}
+			return lexer.Diagnostic{Message: "cannot format without moving this comment", Location: location, Severity: lexer.ERROR}, true
+		}
+	}
+	if len(after) > len(before) {
+		return lexer.Diagnostic{Message: "formatting would add a comment", Location: fileStart, Severity: lexer.ERROR}, true
+	}
+	return lexer.Diagnostic{}, false
+}
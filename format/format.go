@@ -0,0 +1,507 @@
+// Package format prints Terminascript programs in one canonical style.
+package format
+
+import (
+	"math"
+	"strings"
+	"terminascript/lexer"
+	"terminascript/parser"
+)
+
+const indentation = "  "
+
+// Source formats a program. Nothing is formatted if the program has lexical
+// or syntax errors; the errors are returned instead.
+func Source(filename string, source string) (string, []lexer.Diagnostic) {
+	tokens, diagnostics := lexer.NewLexer(filename, source).Lex()
+	if len(diagnostics) > 0 {
+		return "", diagnostics
+	}
+	program, diagnostics := parser.NewParser(tokens).Parse()
+	if len(diagnostics) > 0 {
+		return "", diagnostics
+	}
+
+	p := &printer{source: source, out: &strings.Builder{}}
+	for _, token := range tokens {
+		p.comments = append(p.comments, token.Comments...)
+		for _, comment := range token.Comments {
+			p.multiline(lexer.Span{Start: comment.Pos, End: comment.End})
+		}
+		p.multiline(token.Span())
+	}
+	p.statements(program.Expressions)
+	p.leadingComments(math.MaxInt)
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+
+	formatted := p.out.String()
+	if diagnostic, changed := checkFormatted(filename, tokens, program, formatted); changed {
+		return "", []lexer.Diagnostic{diagnostic}
+	}
+	return formatted, nil
+}
+
+// printer writes statements one per line. Comments are not part of the
+// syntax tree, so they are printed in between statements by source position.
+type printer struct {
+	source   string
+	comments []lexer.Comment
+	out      *strings.Builder
+	indent   int
+
+	// lastLine is the source line of the last thing printed, used to keep
+	// blank lines between statements.
+	lastLine int
+	// blockStart is set after an opening brace, where blank lines are dropped.
+	blockStart bool
+
+	// printed is the source offset up to which code has been printed. A
+	// comment before it can no longer be printed where it was written.
+	printed int
+	// lost is set when that happens, so that the statement is printed as it
+	// was written instead.
+	lost bool
+
+	// multilineTokens are the spans of the tokens and comments that cover
+	// more than one line, whose lines are never re-indented.
+	multilineTokens []lexer.Span
+}
+
+func (p *printer) multiline(span lexer.Span) {
+	if span.End.Line > span.Start.Line {
+		p.multilineTokens = append(p.multilineTokens, span)
+	}
+}
+
+// insideToken reports whether offset is inside a token or comment, rather
+// than at its start.
+func (p *printer) insideToken(offset int) bool {
+	for _, span := range p.multilineTokens {
+		if span.Start.Offset < offset && offset < span.End.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// startLine starts a new output line for something that starts on line in
+// the source, keeping one blank line if there was one before it.
+func (p *printer) startLine(line int) {
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+		if !p.blockStart && line > p.lastLine+1 {
+			p.out.WriteString("\n")
+		}
+	}
+	p.blockStart = false
+	p.out.WriteString(strings.Repeat(indentation, p.indent))
+}
+
+func (p *printer) write(text string) {
+	p.out.WriteString(text)
+}
+
+// nextComment removes and returns the next comment if it starts before
+// offset. A comment in code that has already been printed is left in place
+// and marks the statement as lost.
+func (p *printer) nextComment(offset int) (lexer.Comment, bool) {
+	if len(p.comments) == 0 || p.comments[0].Pos.Offset >= offset {
+		return lexer.Comment{}, false
+	}
+	if p.comments[0].Pos.Offset < p.printed {
+		p.lost = true
+		return lexer.Comment{}, false
+	}
+	comment := p.comments[0]
+	p.comments = p.comments[1:]
+	return comment, true
+}
+
+// nextTrailingComment is like nextComment for a comment that follows code
+// on line.
+func (p *printer) nextTrailingComment(line int, offset int) (lexer.Comment, bool) {
+	if len(p.comments) == 0 || !p.comments[0].Trailing || p.comments[0].Pos.Line != line {
+		return lexer.Comment{}, false
+	}
+	return p.nextComment(offset)
+}
+
+func commentText(comment lexer.Comment) string {
+	return strings.TrimRight(comment.Text, " \t\r")
+}
+
+// leadingComments prints the comments that start before offset, each on its
+// own line.
+func (p *printer) leadingComments(offset int) {
+	for {
+		comment, ok := p.nextComment(offset)
+		if !ok {
+			return
+		}
+		p.startLine(comment.Pos.Line)
+		p.write(commentText(comment))
+		p.lastLine = comment.End.Line
+	}
+}
+
+// trailingComments prints the comments that follow the code on line at the
+// end of the current output line.
+func (p *printer) trailingComments(line int) {
+	for {
+		comment, ok := p.nextTrailingComment(line, math.MaxInt)
+		if !ok {
+			return
+		}
+		p.write(" " + commentText(comment))
+		p.lastLine = comment.End.Line
+	}
+}
+
+func (p *printer) statements(nodes []parser.Node) {
+	for _, node := range nodes {
+		span := node.Span()
+		p.leadingComments(span.Start.Offset)
+		if p.lost {
+			// The comment belongs to the statement around these ones.
+			return
+		}
+		p.startLine(span.Start.Line)
+		p.statementOrSource(node)
+		p.lastLine = span.End.Line
+		p.printed = span.End.Offset
+		p.trailingComments(span.End.Line)
+	}
+}
+
+// statementOrSource prints a statement, or prints it as it was written if
+// it has comments that cannot be kept where they are, such as one between
+// the operands of a binary operator.
+func (p *printer) statementOrSource(node parser.Node) {
+	span := node.Span()
+	saved := *p
+	p.out = &strings.Builder{}
+	p.printed = span.Start.Offset
+	p.statement(node)
+	if _, left := p.nextComment(span.End.Offset); !left && !p.lost {
+		saved.out.WriteString(p.out.String())
+		p.out = saved.out
+		return
+	}
+
+	*p = saved
+	for len(p.comments) > 0 && p.comments[0].Pos.Offset < span.End.Offset {
+		p.comments = p.comments[1:]
+	}
+	p.write(p.sourceText(span))
+	if _, ok := node.(parser.Expression); ok || needsSemicolon(node) {
+		if !strings.HasSuffix(p.sourceText(span), ";") {
+			p.write(";")
+		}
+	}
+}
+
+func needsSemicolon(node parser.Node) bool {
+	switch node.(type) {
+	case parser.AssignmentNode, parser.ReturnNode, parser.BreakNode, parser.ContinueNode:
+		return true
+	}
+	return false
+}
+
+// sourceText returns the source of span, with the indentation of its lines
+// after the first changed to the current indentation. Lines that continue a
+// multi-line string or comment are left alone, since their indentation is
+// part of the text.
+func (p *printer) sourceText(span lexer.Span) string {
+	lineStart := strings.LastIndex(p.source[:span.Start.Offset], "\n") + 1
+	line := p.source[lineStart:span.Start.Offset]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+	lines := strings.Split(p.source[span.Start.Offset:span.End.Offset], "\n")
+	offset := span.Start.Offset
+	for i, text := range lines {
+		if i > 0 && strings.HasPrefix(text, indent) && !p.insideToken(offset) {
+			lines[i] = strings.Repeat(indentation, p.indent) + text[len(indent):]
+		}
+		offset += len(text) + 1
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *printer) statement(node parser.Node) {
+	switch n := node.(type) {
+	case parser.AssignmentNode:
+		target := p.expression(n.Target, parser.LOWEST)
+		if !n.Declaration {
+			p.write(target + " " + parser.AssignmentSymbol(n.Op) + " " + p.expression(n.Value, parser.LOWEST) + ";")
+		} else if n.Value == nil {
+			p.write("let " + target + ";")
+		} else {
+			p.write("let " + target + " := " + p.expression(n.Value, parser.LOWEST) + ";")
+		}
+	case parser.ReturnNode:
+		if n.Expression == nil {
+			p.write("return;")
+		} else {
+			p.write("return " + p.expression(n.Expression, parser.LOWEST) + ";")
+		}
+	case parser.BreakNode, parser.ContinueNode:
+		p.write(n.String() + ";")
+	case parser.IfNode:
+		for i, branch := range n.Cases {
+			if i == 0 {
+				p.write("if ")
+			} else {
+				p.write(" elif ")
+			}
+			p.write(p.condition(branch.Condition) + " ")
+			p.block(branch.Consequence)
+		}
+		if n.HasAlternate() {
+			p.write(" else ")
+			p.block(n.Alternate)
+		}
+	case parser.WhileNode:
+		p.write(label(n.Label) + "while " + p.condition(n.Condition) + " ")
+		p.block(n.Consequence)
+	case parser.ForNode:
+		p.write(label(n.Label) + "for (" + n.Identifier + " := " + p.rangeExpression(n.MinValue, n.MaxValue, n.Step, n.Inclusive) + ") ")
+		p.block(n.Consequence)
+	case parser.ForInNode:
+		variables := n.Value
+		if n.Key != "" {
+			variables = n.Key + ", " + n.Value
+		}
+		p.write(label(n.Label) + "for (" + variables + " in " + p.expression(n.Iterable, parser.LOWEST) + ") ")
+		p.block(n.Consequence)
+	case parser.FunctionDefenitionNode:
+		p.write("func " + n.Identifier + "(" + p.expressions(n.Parameters) + ") ")
+		p.block(n.Consequence)
+	case parser.Expression:
+		p.write(p.expression(n, parser.LOWEST) + ";")
+	}
+}
+
+func label(label string) string {
+	if label == "" {
+		return ""
+	}
+	return label + ": "
+}
+
+// blockString prints a block that is part of an expression, such as the body
+// of an anonymous function, and returns it.
+func (p *printer) blockString(n parser.ProgramNode) string {
+	out := p.out
+	p.out = &strings.Builder{}
+	p.block(n)
+	str := p.out.String()
+	p.out = out
+	return str
+}
+
+// block prints a braced block, leaving the output on its closing brace.
+func (p *printer) block(n parser.ProgramNode) {
+	end := n.Location.End
+	if len(n.Expressions) == 0 && (len(p.comments) == 0 || p.comments[0].Pos.Offset >= end.Offset) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.lastLine = n.Location.Start.Line
+	p.printed = n.Location.Start.Offset + 1
+	p.trailingComments(n.Location.Start.Line)
+
+	p.indent++
+	p.blockStart = true
+	p.statements(n.Expressions)
+	p.leadingComments(end.Offset)
+	p.indent--
+
+	p.blockStart = true
+	p.startLine(end.Line)
+	p.write("}")
+	p.printed = end.Offset
+}
+
+func (p *printer) rangeExpression(start parser.Expression, end parser.Expression, step parser.Expression, inclusive bool) string {
+	arrow := " -> "
+	if inclusive {
+		arrow = " ->= "
+	}
+	str := p.expression(start, parser.LOWEST) + arrow + p.expression(end, parser.LOWEST)
+	if step != nil {
+		str += " step " + p.expression(step, parser.LOWEST)
+	}
+	return str
+}
+
+func (p *printer) condition(condition parser.Expression) string {
+	return "(" + p.expression(condition, parser.LOWEST) + ")"
+}
+
+func (p *printer) expressions(expressions []parser.Expression) string {
+	strs := make([]string, 0, len(expressions))
+	for _, expression := range expressions {
+		strs = append(strs, p.expression(expression, parser.LOWEST))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// expression prints an expression, in parentheses if it binds looser than
+// precedence. Literals are printed as they were written.
+func (p *printer) expression(node parser.Expression, precedence int) string {
+	var str string
+	switch n := node.(type) {
+	case parser.BinaryOperationNode:
+		// Binary operators are left associative, so only the right operand
+		// needs parentheses at the same precedence.
+		op := parser.Precedence(n.Op)
+		str = p.expression(n.Left, op) + " " + lexer.Symbol(n.Op) + " " + p.expression(n.Right, op+1)
+	case parser.ConditionalNode:
+		str = p.expression(n.Condition, parser.CONDITIONAL+1) + " : " + p.expression(n.Consequence, parser.CONDITIONAL+1) + " ? " + p.expression(n.Alternate, parser.CONDITIONAL)
+	case parser.UnaryOpNode:
+		str = lexer.Symbol(n.Op) + p.expression(n.Right, parser.POSTFIX)
+	case parser.UpdateNode:
+		op := lexer.Symbol(n.Op) + lexer.Symbol(n.Op)
+		if n.Prefix {
+			str = op + p.expression(n.Target, parser.POSTFIX)
+		} else {
+			str = p.expression(n.Target, parser.POSTFIX) + op
+		}
+	case parser.FunctionCallNode:
+		str = p.expression(n.Function, parser.POSTFIX)
+		listStart := n.Function.Span().End.Offset
+		str += p.list("(", ")", listStart, n.Location.End.Offset, len(n.Parameters), false, func(i int) (parser.Expression, parser.Expression) {
+			return n.Parameters[i], n.Parameters[i]
+		}, func(i int) string {
+			return p.expression(n.Parameters[i], parser.LOWEST)
+		})
+	case parser.FunctionNode:
+		str = "func (" + p.expressions(n.Parameters) + ") " + p.blockString(n.Consequence)
+	case parser.ArrowFunctionNode:
+		str = "(" + p.expressions(n.Parameters) + ") => " + p.expression(n.Body, parser.LOWEST)
+	case parser.VarAccessNode:
+		str = n.Identifier
+	case parser.ArrayNode:
+		str = p.list("[", "]", n.Location.Start.Offset, n.Location.End.Offset, len(n.Elements), true, func(i int) (parser.Expression, parser.Expression) {
+			return n.Elements[i], n.Elements[i]
+		}, func(i int) string {
+			return p.expression(n.Elements[i], parser.LOWEST)
+		})
+	case parser.MapNode:
+		str = p.list("{", "}", n.Location.Start.Offset, n.Location.End.Offset, len(n.Keys), true, func(i int) (parser.Expression, parser.Expression) {
+			return n.Keys[i], n.Values[i]
+		}, func(i int) string {
+			return p.expression(n.Keys[i], parser.CONDITIONAL+1) + ": " + p.expression(n.Values[i], parser.LOWEST)
+		})
+	case parser.MemberNode:
+		str = p.expression(n.Left, parser.POSTFIX) + "." + n.Name
+	case parser.IndexNode:
+		str = p.expression(n.Left, parser.POSTFIX) + "[" + p.expression(n.Index, parser.CONDITIONAL+1) + "]"
+	case parser.SliceNode:
+		str = p.expression(n.Left, parser.POSTFIX) + "["
+		if n.Start != nil {
+			str += p.expression(n.Start, parser.CONDITIONAL+1)
+		}
+		str += ":"
+		if n.End != nil {
+			str += p.expression(n.End, parser.CONDITIONAL+1)
+		}
+		str += "]"
+	case parser.RangeNode:
+		str = p.rangeExpression(n.Start, n.End, n.Step, n.Inclusive)
+	case parser.IntNode, parser.FloatNode, parser.StringNode, parser.InterpolationNode:
+		span := n.Span()
+		str = p.source[span.Start.Offset:span.End.Offset]
+	default:
+		str = n.String()
+	}
+
+	if bindingPower(node) < precedence {
+		return "(" + str + ")"
+	}
+	return str
+}
+
+// list prints the items of an array, map or call, which lie between the
+// source offsets start and end. If there are comments among them, each item
+// goes on its own line so the comments can stay next to the items they were
+// written by. bounds returns the first and last node of an item.
+func (p *printer) list(open string, close string, start int, end int, count int, trailingComma bool, bounds func(i int) (parser.Expression, parser.Expression), item func(i int) string) string {
+	if len(p.comments) == 0 || p.comments[0].Pos.Offset >= end {
+		items := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			items = append(items, item(i))
+		}
+		return open + strings.Join(items, ", ") + close
+	}
+
+	p.printed = start
+	p.indent++
+	str := open
+	for i := 0; i < count; i++ {
+		first, last := bounds(i)
+		str += p.commentLines(first.Span().Start.Offset)
+		p.printed = first.Span().Start.Offset
+		str += "\n" + strings.Repeat(indentation, p.indent) + item(i)
+		if trailingComma || i < count-1 {
+			str += ","
+		}
+
+		// A comment after the item on its line belongs to it, unless the
+		// next item starts on that line too.
+		p.printed = last.Span().End.Offset
+		line := last.Span().End.Line
+		if i < count-1 {
+			if next, _ := bounds(i + 1); next.Span().Start.Line == line {
+				continue
+			}
+		}
+		for {
+			comment, ok := p.nextTrailingComment(line, end)
+			if !ok {
+				break
+			}
+			str += " " + commentText(comment)
+		}
+	}
+	str += p.commentLines(end)
+	p.indent--
+	p.printed = end
+	return str + "\n" + strings.Repeat(indentation, p.indent) + close
+}
+
+// commentLines returns the comments that start before offset, each on a
+// line of its own.
+func (p *printer) commentLines(offset int) string {
+	str := ""
+	for {
+		comment, ok := p.nextComment(offset)
+		if !ok {
+			return str
+		}
+		str += "\n" + strings.Repeat(indentation, p.indent) + commentText(comment)
+	}
+}
+
+func bindingPower(node parser.Expression) int {
+	switch n := node.(type) {
+	case parser.BinaryOperationNode:
+		return parser.Precedence(n.Op)
+	case parser.ConditionalNode:
+		return parser.CONDITIONAL
+	case parser.ArrowFunctionNode:
+		return parser.LOWEST
+	case parser.UnaryOpNode:
+		return parser.PREFIX
+	case parser.UpdateNode:
+		if n.Prefix {
+			return parser.PREFIX
+		}
+	}
+	return parser.POSTFIX
+}
@@ -0,0 +1,196 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"terminascript/lexer"
+	"terminascript/parser"
+	"testing"
+)
+
+var formatCases = []struct {
+	name   string
+	source string
+	want   string
+}{
+	{
+		name:   "spacing",
+		source: "let x:=1+2*3;print( x )",
+		want:   "let x := 1 + 2 * 3;\nprint(x);\n",
+	},
+	{
+		name:   "parentheses",
+		source: "print(((1 + 2)) * 3, 1 - (2 - 3), (1 - 2) - 3);",
+		want:   "print((1 + 2) * 3, 1 - (2 - 3), 1 - 2 - 3);\n",
+	},
+	{
+		name:   "blocks and blank lines",
+		source: "if (x) { print(1); }\n\n\nelif (y) {} else { print(2); }\nfunc f(a, b) {\n\n  return a;\n}\n",
+		want:   "if (x) {\n  print(1);\n} elif (y) {} else {\n  print(2);\n}\nfunc f(a, b) {\n  return a;\n}\n",
+	},
+	{
+		name:   "comments between statements",
+		source: "// first\nlet a := 1; // trailing\n\n/* block */\nprint(a);\n// last\n",
+		want:   "// first\nlet a := 1; // trailing\n\n/* block */\nprint(a);\n// last\n",
+	},
+	{
+		name:   "comments in an array",
+		source: "let a := [\n1, // one\n2 // two\n];\nprint(a);\n",
+		want:   "let a := [\n  1, // one\n  2, // two\n];\nprint(a);\n",
+	},
+	{
+		name:   "comment before a call argument",
+		source: "print(\n // lead\n func () { return 1; }(), 2);\n",
+		want:   "print(\n  // lead\n  func () {\n    return 1;\n  }(),\n  2\n);\n",
+	},
+	{
+		name:   "comments in a map",
+		source: "let m := {\"a\": 1, // first\n// before b\n\"b\": 2};\n",
+		want:   "let m := {\n  \"a\": 1, // first\n  // before b\n  \"b\": 2,\n};\n",
+	},
+	{
+		name:   "comment inside an expression",
+		source: "if (1) {\n    let y := 3 +\n        // odd\n        4;\n}\n",
+		want:   "if (1) {\n  let y := 3 +\n      // odd\n      4;\n}\n",
+	},
+	{
+		name:   "raw string kept when printed as written",
+		source: "if (1) {\nlet s := `a\n    b` /* c */ + \"x\";\n}\n",
+		want:   "if (1) {\n  let s := `a\n    b` /* c */ + \"x\";\n}\n",
+	},
+	{
+		name:   "raw string indentation not removed",
+		source: "if (1) {\n    let s := `r\n  q` /* c */ + \"\";\n}\n",
+		want:   "if (1) {\n  let s := `r\n  q` /* c */ + \"\";\n}\n",
+	},
+	{
+		name:   "triple quoted string",
+		source: "if (1) {\n  let s := \"\"\"\n    one\n      two\n    \"\"\";\n}\n",
+		want:   "if (1) {\n  let s := \"\"\"\n    one\n      two\n    \"\"\";\n}\n",
+	},
+	{
+		name:   "literals as written",
+		source: "let n := 0xff + 1_000 + 1e3;\nlet s := \"a\\tb${n}\";\n",
+		want:   "let n := 0xff + 1_000 + 1e3;\nlet s := \"a\\tb${n}\";\n",
+	},
+	{
+		name:   "loops and functions",
+		source: "outer: for (i := 0 ->= 10 step 2) { for (k, v in {\"a\": 1}) { continue outer; } }\nlet f := (x) => x * 2;\nprint(((x) => x)(1));\n",
+		want:   "outer: for (i := 0 ->= 10 step 2) {\n  for (k, v in {\"a\": 1}) {\n    continue outer;\n  }\n}\nlet f := (x) => x * 2;\nprint(((x) => x)(1));\n",
+	},
+}
+
+func TestSource(t *testing.T) {
+	for _, c := range formatCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := checkSource(t, c.name, c.source)
+			if got != c.want {
+				t.Errorf("Source(%q) =\n%s\nwant\n%s", c.source, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSourceExamples(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.term")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSource(t, file, string(source))
+		})
+	}
+}
+
+// checkSource formats source and checks that formatting again changes
+// nothing and that the formatted program parses to the same tree.
+func checkSource(t *testing.T, filename string, source string) string {
+	t.Helper()
+	formatted, diagnostics := Source(filename, source)
+	if len(diagnostics) > 0 {
+		t.Fatalf("Source(%q) reported %v", source, diagnostics)
+	}
+
+	again, diagnostics := Source(filename, formatted)
+	if len(diagnostics) > 0 {
+		t.Fatalf("formatting again reported %v for\n%s", diagnostics, formatted)
+	}
+	if again != formatted {
+		t.Errorf("formatting again gave\n%s\nafter\n%s", again, formatted)
+	}
+
+	if before, after := parse(t, source), parse(t, formatted); before != after {
+		t.Errorf("formatting changed the program from\n%s\nto\n%s", before, after)
+	}
+	return formatted
+}
+
+func parse(t *testing.T, source string) string {
+	t.Helper()
+	tokens, diagnostics := lexer.NewLexer("test", source).Lex()
+	if len(diagnostics) > 0 {
+		t.Fatalf("lexing %q reported %v", source, diagnostics)
+	}
+	program, diagnostics := parser.NewParser(tokens).Parse()
+	if len(diagnostics) > 0 {
+		t.Fatalf("parsing %q reported %v", source, diagnostics)
+	}
+	return program.String()
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	formatted, diagnostics := Source("test", "let x := ;")
+	if formatted != "" || len(diagnostics) == 0 {
+		t.Errorf("Source gave %q and %v, want a diagnostic and no output", formatted, diagnostics)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"insertion",
+			"a\nb\n",
+			"a\nx\nb\n",
+			"--- a.orig\n+++ a\n@@ -1,2 +1,3 @@\n a\n+x\n b\n",
+		},
+		{
+			"separate hunks",
+			"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n",
+			"a\nB\nc\nd\ne\nf\ng\nh\ni\nJ\n",
+			"--- a.orig\n+++ a\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -7,4 +7,4 @@\n g\n h\n i\n-j\n+J\n",
+		},
+		{
+			"joined hunks",
+			"a\nb\nc\nd\ne\nf\ng\nh\ni\n",
+			"a\nB\nc\nd\ne\nf\nG\nh\ni\n",
+			"--- a.orig\n+++ a\n@@ -1,9 +1,9 @@\n a\n-b\n+B\n c\n d\n e\n f\n-g\n+G\n h\n i\n",
+		},
+		{
+			"no newline at end",
+			"x\ny",
+			"x\nz\n",
+			"--- a.orig\n+++ a\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+z\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Diff("a.orig", "a", c.old, c.new); got != c.want {
+				t.Errorf("Diff =\n%q\nwant\n%q", got, c.want)
+			}
+		})
+	}
+}
@@ -1,80 +1,288 @@
 package lexer
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lexer turns source text into tokens. It reads from an io.Reader one rune
+// at a time, so tokens can be handed to the parser as they are needed
+// rather than lexing the whole program up front.
 type Lexer struct {
-	program      string
-	position     int
-	readPosition int
-	ch           byte
+	filename string
+	reader   *bufio.Reader
+	peeked   []char
+	position int
+	width    int
+	line     int
+	column   int
+	ch       rune
+
+	// text holds the characters read since the start of the current
+	// token, including its leading comments.
+	text []byte
+
+	comments    []Comment
+	lastLine    int
+	diagnostics []Diagnostic
+
+	interpolations []interpolation
+	depth          int
+	incomplete     bool
+}
+
+// char is a rune read from the source along with its width in bytes. A
+// zero width marks the end of the input.
+type char struct {
+	ch    rune
+	width int
 }
 
-func NewLexer(program string) *Lexer {
-	l := &Lexer{program: program}
+// interpolation tracks an interpolated string whose ${ expression is being
+// lexed. depth counts the braces opened inside the expression.
+type interpolation struct {
+	start Position
+	depth int
+}
+
+func NewLexer(filename string, program string) *Lexer {
+	return NewReaderLexer(filename, strings.NewReader(program))
+}
+
+func NewReaderLexer(filename string, reader io.Reader) *Lexer {
+	l := &Lexer{filename: filename, reader: bufio.NewReader(reader), line: 1}
 	l.readChar()
+	if l.ch == '#' && l.peekChar() == '!' {
+		l.readShebang()
+	}
 	return l
 }
 
-func (l *Lexer) Lex() []Token {
+// Incomplete reports whether the input ended inside a string, comment or
+// unclosed bracket, meaning more input could complete the program.
+func (l *Lexer) Incomplete() bool {
+	return l.incomplete || l.depth > 0
+}
+
+// Lex reads every token in the program, along with any lexical errors.
+// ILLEGAL tokens are kept in the token list, so a caller should not parse a
+// program that has diagnostics.
+func (l *Lexer) Lex() ([]Token, []Diagnostic) {
 	var tokens []Token
-	for l.ch != 0 {
+	for {
 		tok := l.NextToken()
 		tokens = append(tokens, tok)
+		if tok.Type == EOF {
+			sort.SliceStable(l.diagnostics, func(i, j int) bool {
+				return l.diagnostics[i].Location.Start.Offset < l.diagnostics[j].Location.Start.Offset
+			})
+			return tokens, l.diagnostics
+		}
 	}
-
-	tokens = append(tokens, Token{Type: EOF, Literal: ""})
-	return tokens
 }
 
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.program) {
-		l.ch = 0
-	} else {
-		l.ch = l.program[l.readPosition]
+	if l.column > 0 && l.width == 0 {
+		return
 	}
 
-	l.position = l.readPosition
-	l.readPosition++
+	if l.width > 0 {
+		l.text = append(l.text, string(l.ch)...)
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
+	next := l.nextChar()
+	l.position += l.width
+	l.column++
+	l.ch, l.width = next.ch, next.width
+	if next.ch == utf8.RuneError && next.width == 1 {
+		start := l.currentPosition()
+		l.addDiagnostic("invalid UTF-8 encoding", Span{start, start})
+	}
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.program) {
-		return 0
-	} else {
-		return l.program[l.readPosition]
+// atEOF reports whether the input has run out. A NUL character in the
+// source is not the end of the input.
+func (l *Lexer) atEOF() bool {
+	return l.width == 0
+}
+
+func (l *Lexer) nextChar() char {
+	if len(l.peeked) > 0 {
+		next := l.peeked[0]
+		l.peeked = l.peeked[1:]
+		return next
+	}
+	return l.readRune()
+}
+
+func (l *Lexer) readRune() char {
+	ch, width, err := l.reader.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			start := l.currentPosition()
+			l.addDiagnostic(err.Error(), Span{start, start})
+		}
+		return char{0, 0}
 	}
+	return char{ch, width}
+}
+
+func (l *Lexer) currentPosition() Position {
+	return Position{Filename: l.filename, Offset: l.position, Line: l.line, Column: l.column}
+}
+
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(1)
+}
+
+// peekCharAt returns the character n places after the current one.
+func (l *Lexer) peekCharAt(n int) rune {
+	for len(l.peeked) < n {
+		l.peeked = append(l.peeked, l.readRune())
+	}
+	return l.peeked[n-1].ch
+}
+
+// mark returns a marker for the current character, for use with since.
+func (l *Lexer) mark() int {
+	return len(l.text)
+}
+
+// since returns the text read from the marked character up to, but not
+// including, the current character.
+func (l *Lexer) since(mark int) string {
+	return string(l.text[mark:])
 }
 
 func (l *Lexer) NextToken() Token {
+	l.text = l.text[:0]
+	l.eatTrivia()
+	start := l.currentPosition()
+	tok := l.readToken()
+	tok.Pos = start
+	tok.End = l.currentPosition()
+	tok.Comments = l.comments
+	l.comments = nil
+	l.lastLine = tok.End.Line
+	if tok.Type == ILLEGAL && !l.reportedAt(tok.Pos) {
+		l.addDiagnostic(illegalMessage(tok.Literal), tok.Span())
+	}
+	return tok
+}
+
+// reportedAt reports whether the last diagnostic starts at position, as
+// one for invalid UTF-8 does when readChar finds it.
+func (l *Lexer) reportedAt(position Position) bool {
+	n := len(l.diagnostics)
+	return n > 0 && l.diagnostics[n-1].Location.Start == position
+}
+
+func illegalMessage(literal string) string {
+	switch {
+	case literal == "&":
+		return "unexpected '&', did you mean '&&'?"
+	case literal == "|":
+		return "unexpected '|', did you mean '||'?"
+	case isDigit(rune(literal[0])):
+		return fmt.Sprintf("malformed number literal %s", literal)
+	}
+	ch, _ := utf8.DecodeRuneInString(literal)
+	return fmt.Sprintf("unexpected character %q", ch)
+}
+
+func (l *Lexer) readToken() Token {
+	if l.atEOF() {
+		for _, open := range l.interpolations {
+			l.addDiagnostic("unterminated string", Span{open.start, l.currentPosition()})
+			l.incomplete = true
+		}
+		l.interpolations = nil
+		return Token{Type: EOF, Literal: ""}
+	}
+
 	var tok Token
-	l.eatWhitespace()
 	switch l.ch {
 	case '+':
-		tok = NewToken(ADD, l.ch)
+		if l.peekChar() == '+' {
+			tok = l.readDouble(ADD, '+', INCREMENT)
+		} else {
+			tok = l.readDouble(ADD, '=', ADD_EQ)
+		}
 	case '-':
-		tok = l.readDouble(SUB, '>', ARROW)
+		switch l.peekChar() {
+		case '>':
+			if l.peekCharAt(2) == '=' {
+				l.readChar()
+				l.readChar()
+				tok = Token{Type: INCLUSIVE_ARROW, Literal: "->="}
+			} else {
+				tok = l.readDouble(SUB, '>', ARROW)
+			}
+		case '-':
+			tok = l.readDouble(SUB, '-', DECREMENT)
+		default:
+			tok = l.readDouble(SUB, '=', SUB_EQ)
+		}
 	case '*':
-		tok = NewToken(MUL, l.ch)
+		tok = l.readDouble(MUL, '=', MUL_EQ)
 	case '/':
-		tok = NewToken(DIV, l.ch)
+		tok = l.readDouble(DIV, '=', DIV_EQ)
 	case '(':
 		tok = NewToken(LPAREN, l.ch)
+		l.depth++
 	case ')':
 		tok = NewToken(RPAREN, l.ch)
+		l.depth--
+	case '[':
+		tok = NewToken(LBRACKET, l.ch)
+		l.depth++
+	case ']':
+		tok = NewToken(RBRACKET, l.ch)
+		l.depth--
 	case '{':
 		tok = NewToken(LBRACE, l.ch)
+		l.depth++
+		if len(l.interpolations) > 0 {
+			l.interpolations[len(l.interpolations)-1].depth++
+		}
 	case '}':
 		tok = NewToken(RBRACE, l.ch)
+		if len(l.interpolations) > 0 {
+			top := &l.interpolations[len(l.interpolations)-1]
+			if top.depth == 0 {
+				return l.readInterpolationEnd(top.start)
+			}
+			top.depth--
+		}
+		l.depth--
 	case '?':
 		tok = NewToken(QUESTION, l.ch)
 	case ';':
 		tok = NewToken(SEMICOLON, l.ch)
 	case ',':
 		tok = NewToken(COMMA, l.ch)
+	case '.':
+		tok = NewToken(DOT, l.ch)
 	case '%':
-		tok = NewToken(MOD, l.ch)
+		tok = l.readDouble(MOD, '=', MOD_EQ)
 	case ':':
 		tok = l.readDouble(COLON, '=', ASSIGN)
 	case '=':
-		tok = l.readDouble(EQ, '=', EE)
+		if l.peekChar() == '>' {
+			tok = l.readDouble(EQ, '>', FAT_ARROW)
+		} else {
+			tok = l.readDouble(EQ, '=', EE)
+		}
 	case '>':
 		tok = l.readDouble(GT, '=', GTE)
 	case '<':
@@ -85,19 +293,27 @@ func (l *Lexer) NextToken() Token {
 		tok = l.readDouble(ILLEGAL, '&', AND)
 	case '|':
 		tok = l.readDouble(ILLEGAL, '|', OR)
+	case '`':
+		tok = Token{Type: STRING, Literal: l.readRawString()}
 	case '"':
-		tok.Literal = l.readString()
-		tok.Type = STRING
-	case 0:
-		tok = NewToken(EOF, l.ch)
+		start := l.currentPosition()
+		if l.peekChar() == '"' && l.peekCharAt(2) == '"' {
+			tok = Token{Type: STRING, Literal: l.readTripleQuotedString()}
+			break
+		}
+		literal, interpolated := l.readString(start)
+		tok = Token{Type: STRING, Literal: literal}
+		if interpolated {
+			tok.Type = STRING_START
+			l.interpolations = append(l.interpolations, interpolation{start: start})
+		}
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = lookupIdentifier(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
 			return tok
 		} else {
 			tok = NewToken(ILLEGAL, l.ch)
@@ -113,43 +329,373 @@ func (l *Lexer) eatWhitespace() {
 	}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// eatTrivia skips whitespace and comments, keeping the comments so they can
+// be attached to the next token.
+func (l *Lexer) eatTrivia() {
+	for {
+		l.eatWhitespace()
+		if l.ch == '/' && l.peekChar() == '/' {
+			l.readLineComment()
+		} else if l.ch == '/' && l.peekChar() == '*' {
+			l.readBlockComment()
+		} else {
+			return
+		}
+	}
+}
+
+func (l *Lexer) readLineComment() {
+	start, mark := l.currentPosition(), l.mark()
+	for l.ch != '\n' && !l.atEOF() {
+		l.readChar()
+	}
+	l.addComment(start, mark)
+}
+
+// readBlockComment reads a /* */ comment, which may contain nested block
+// comments.
+func (l *Lexer) readBlockComment() {
+	start, mark := l.currentPosition(), l.mark()
+	depth := 0
+	for {
+		if l.atEOF() {
+			l.addDiagnostic("unterminated block comment", Span{start, l.currentPosition()})
+			l.incomplete = true
+			break
+		}
+		if l.ch == '/' && l.peekChar() == '*' {
+			depth++
+			l.readChar()
+		} else if l.ch == '*' && l.peekChar() == '/' {
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+		if depth == 0 {
+			break
+		}
+	}
+	l.addComment(start, mark)
 }
 
+// readShebang reads a leading #! line so scripts can be run directly.
+func (l *Lexer) readShebang() {
+	l.readLineComment()
+}
+
+func (l *Lexer) addComment(start Position, mark int) {
+	end := l.currentPosition()
+	text := l.since(mark)
+	trailing := l.lastLine == start.Line
+	l.comments = append(l.comments, Comment{Text: text, Pos: start, End: end, Trailing: trailing})
+}
+
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
+}
+
+// readIdentifier reads an identifier, which starts with a letter and may
+// continue with letters and digits from any script.
 func (l *Lexer) readIdentifier() string {
-	position := l.position
-	for isLetter(l.ch) {
+	mark := l.mark()
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
-	return l.program[position:l.position]
+	return l.since(mark)
 }
 
-func isDigit(ch byte) bool {
+// isDigit reports whether ch is an ASCII digit, the only digits allowed in
+// number literals.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readNumber() string {
-	position := l.position
-	for isDigit(l.ch) {
+// readNumber reads an integer or floating-point literal. Integers may use a
+// 0x, 0b or 0o prefix and any number may use _ to separate digits. The
+// literal is returned as written; malformed numbers are ILLEGAL.
+func (l *Lexer) readNumber() (string, string) {
+	mark := l.mark()
+
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		l.readChar()
+		base := l.ch
 		l.readChar()
+		valid := l.readDigits(func(ch rune) bool { return isDigitInBase(ch, base) })
+		if !valid || isLetter(l.ch) || isDigit(l.ch) {
+			l.readMalformedTail()
+			return ILLEGAL, l.since(mark)
+		}
+		return INT, l.since(mark)
 	}
 
-	return l.program[position:l.position]
+	tokenType := INT
+	valid := l.readDigits(isDigit)
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = FLOAT
+		l.readChar()
+		valid = l.readDigits(isDigit) && valid
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(2))) {
+			tokenType = FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			valid = l.readDigits(isDigit) && valid
+		}
+	}
+
+	if !valid || isLetter(l.ch) {
+		l.readMalformedTail()
+		return ILLEGAL, l.since(mark)
+	}
+	return tokenType, l.since(mark)
 }
 
-func (l *Lexer) readString() string {
+// readMalformedTail reads the rest of a malformed number, so that it is
+// reported as one literal.
+func (l *Lexer) readMalformedTail() {
+	for isLetter(l.ch) || isDigit(l.ch) {
+		l.readChar()
+	}
+}
+
+// readDigits reads a run of digits that may be separated by single
+// underscores, reporting whether the run was well formed.
+func (l *Lexer) readDigits(isValid func(rune) bool) bool {
+	valid := isValid(l.ch)
+	for isValid(l.ch) || l.ch == '_' {
+		if l.ch == '_' && !isValid(l.peekChar()) {
+			valid = false
+		}
+		l.readChar()
+	}
+	return valid
+}
+
+func isBasePrefix(ch rune) bool {
+	return ch == 'x' || ch == 'X' || ch == 'b' || ch == 'B' || ch == 'o' || ch == 'O'
+}
+
+func isDigitInBase(ch rune, base rune) bool {
+	switch base {
+	case 'x', 'X':
+		return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+	case 'b', 'B':
+		return ch == '0' || ch == '1'
+	case 'o', 'O':
+		return '0' <= ch && ch <= '7'
+	}
+	return false
+}
+
+// readString reads a double quoted string, decoding escape sequences, up to
+// the closing quote or the ${ of an interpolated expression. A string with
+// no closing quote is reported at its opening quote, start.
+func (l *Lexer) readString(start Position) (string, bool) {
 	l.readChar()
-	position := l.position
 
+	var str strings.Builder
 	for l.ch != '"' {
+		if l.atEOF() {
+			l.addDiagnostic("unterminated string", Span{start, l.currentPosition()})
+			l.incomplete = true
+			return str.String(), false
+		}
+		switch l.ch {
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				return str.String(), true
+			}
+			str.WriteRune(l.ch)
+			l.readChar()
+		case '\\':
+			l.readEscape(&str)
+		default:
+			str.WriteRune(l.ch)
+			l.readChar()
+		}
+	}
+
+	return str.String(), false
+}
+
+// readRawString reads a backtick string, which may span lines and has no
+// escape sequences.
+func (l *Lexer) readRawString() string {
+	start := l.currentPosition()
+	l.readChar()
+	mark := l.mark()
+	for l.ch != '`' {
+		if l.atEOF() {
+			l.addDiagnostic("unterminated raw string", Span{start, l.currentPosition()})
+			l.incomplete = true
+			break
+		}
 		l.readChar()
 	}
+	return l.since(mark)
+}
+
+// readTripleQuotedString reads a """ string. Like a raw string it has no
+// escape sequences, but blank first and last lines are dropped and the
+// indentation common to every other line is removed, so the text can be
+// indented along with the surrounding code.
+func (l *Lexer) readTripleQuotedString() string {
+	start := l.currentPosition()
+	l.readChar()
+	l.readChar()
+	l.readChar()
+	mark := l.mark()
+	for !(l.ch == '"' && l.peekChar() == '"' && l.peekCharAt(2) == '"') {
+		if l.atEOF() {
+			l.addDiagnostic("unterminated string", Span{start, l.currentPosition()})
+			l.incomplete = true
+			return dedent(l.since(mark))
+		}
+		l.readChar()
+	}
+	text := l.since(mark)
+	l.readChar()
+	l.readChar()
+	return dedent(text)
+}
+
+func dedent(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	// The indentation removed is the longest run of leading whitespace that
+	// every line with text starts with.
+	indent, found := "", false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		leading := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			indent, found = leading, true
+			continue
+		}
+		n := 0
+		for n < len(indent) && n < len(leading) && indent[n] == leading[n] {
+			n++
+		}
+		indent = indent[:n]
+	}
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, indent) {
+			lines[i] = line[len(indent):]
+		} else if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readInterpolationEnd continues an interpolated string after the } that
+// closes one of its expressions.
+func (l *Lexer) readInterpolationEnd(start Position) Token {
+	literal, interpolated := l.readString(start)
+	l.readChar()
+	if interpolated {
+		return Token{Type: STRING_MID, Literal: literal}
+	}
+	l.interpolations = l.interpolations[:len(l.interpolations)-1]
+	return Token{Type: STRING_END, Literal: literal}
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'$':  '$',
+	'\'': '\'',
+}
+
+// readEscape decodes the escape sequence at the current backslash. Unicode
+// escapes are written \u{1F600} and byte escapes \x41.
+func (l *Lexer) readEscape(str *strings.Builder) {
+	start := l.currentPosition()
+	l.readChar()
+	if l.atEOF() {
+		return
+	}
+
+	if ch, ok := escapes[l.ch]; ok {
+		str.WriteRune(ch)
+		l.readChar()
+		return
+	}
+
+	switch l.ch {
+	case 'u':
+		l.readChar()
+		if l.ch != '{' {
+			l.addDiagnostic("expected { after \\u", Span{start, l.currentPosition()})
+			return
+		}
+		l.readChar()
+		digits := l.readHexDigits(6)
+		if l.ch != '}' {
+			l.addDiagnostic("unterminated unicode escape", Span{start, l.currentPosition()})
+			return
+		}
+		l.readChar()
+		value, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(value)) {
+			l.addDiagnostic("invalid unicode escape \\u{"+digits+"}", Span{start, l.currentPosition()})
+			return
+		}
+		str.WriteRune(rune(value))
+	case 'x':
+		l.readChar()
+		digits := l.readHexDigits(2)
+		if len(digits) != 2 {
+			l.addDiagnostic("expected two hex digits after \\x", Span{start, l.currentPosition()})
+			return
+		}
+		value, _ := strconv.ParseUint(digits, 16, 8)
+		str.WriteByte(byte(value))
+	default:
+		ch := l.ch
+		l.readChar()
+		l.addDiagnostic("unknown escape sequence \\"+string(ch), Span{start, l.currentPosition()})
+	}
+}
+
+func (l *Lexer) readHexDigits(max int) string {
+	mark := l.mark()
+	for i := 0; i < max && isDigitInBase(l.ch, 'x'); i++ {
+		l.readChar()
+	}
+	return l.since(mark)
+}
+
+func (l *Lexer) addDiagnostic(message string, location Span) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{message, location, ERROR})
+}
 
-	return l.program[position:l.position]
+// Diagnostics returns the problems found in the source so far.
+func (l *Lexer) Diagnostics() []Diagnostic {
+	return l.diagnostics
 }
 
-func (l *Lexer) readDouble(firstType string, second byte, secondType string) Token {
+func (l *Lexer) readDouble(firstType string, second rune, secondType string) Token {
 	ch := l.ch
 	if l.peekChar() == second {
 		l.readChar()
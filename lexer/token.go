@@ -1,11 +1,100 @@
 package lexer
 
+import (
+	"fmt"
+	"strings"
+)
+
+// Position is a location in a source file. Line and Column start at 1,
+// Offset is the byte offset from the start of the file.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Span covers the source text from Start up to, but not including, End.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
+// Diagnostic is a problem with the source text at Location. Severity is
+// ERROR or WARNING.
+type Diagnostic struct {
+	Message  string
+	Location Span
+	Severity string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s: %s", d.Location, d.Severity, d.Message)
+}
+
+// Comment is a line comment, block comment or shebang line, kept as trivia
+// on the token that follows it. Text includes the comment delimiters.
+// Trailing comments start on the line the previous token ended on.
+type Comment struct {
+	Text     string
+	Pos      Position
+	End      Position
+	Trailing bool
+}
+
 type Token struct {
-	Type    string
-	Literal string
+	Type     string
+	Literal  string
+	Pos      Position
+	End      Position
+	Comments []Comment
+}
+
+func (t Token) Span() Span {
+	return Span{Start: t.Pos, End: t.End}
 }
 
-func NewToken(tokenType string, ch byte) Token {
+// Doc returns the text of the comments directly above the token, without
+// their delimiters. A blank line between a comment and the token ends the
+// doc comment.
+func (t Token) Doc() string {
+	var lines []string
+	line := t.Pos.Line
+	for i := len(t.Comments) - 1; i >= 0; i-- {
+		comment := t.Comments[i]
+		if comment.End.Line < line-1 || comment.Trailing || strings.HasPrefix(comment.Text, "#!") {
+			break
+		}
+		lines = append([]string{commentText(comment.Text)}, lines...)
+		line = comment.Pos.Line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func commentText(text string) string {
+	if strings.HasPrefix(text, "//") {
+		return strings.TrimSpace(text[2:])
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		lines = append(lines, strings.TrimPrefix(strings.TrimSpace(line), "* "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func NewToken(tokenType string, ch rune) Token {
 	return Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -16,22 +105,56 @@ func lookupIdentifier(identifier string) string {
 	return IDENTIFIER
 }
 
+// Symbol returns the source text of an operator or punctuation token type,
+// or the type itself for other tokens.
+func Symbol(tokenType string) string {
+	if symbol, ok := symbols[tokenType]; ok {
+		return symbol
+	}
+	return tokenType
+}
+
+var symbols = map[string]string{
+	ADD: "+", SUB: "-", MUL: "*", DIV: "/", MOD: "%",
+	ADD_EQ: "+=", SUB_EQ: "-=", MUL_EQ: "*=", DIV_EQ: "/=", MOD_EQ: "%=",
+	INCREMENT: "++", DECREMENT: "--",
+	EE: "==", EQ: "=", NOT: "!", NE: "!=",
+	LT: "<", GT: ">", LTE: "<=", GTE: ">=",
+	AND: "&&", OR: "||",
+	QUESTION: "?", COLON: ":", COMMA: ",", DOT: ".", SEMICOLON: ";", ASSIGN: ":=", ARROW: "->", INCLUSIVE_ARROW: "->=", FAT_ARROW: "=>",
+	LPAREN: "(", RPAREN: ")", LBRACE: "{", RBRACE: "}", LBRACKET: "[", RBRACKET: "]",
+}
+
 var keywords = map[string]string{
-	"if":     IF,
-	"while":  WHILE,
-	"for":    FOR,
-	"func":   FUNC,
-	"let":    LET,
-	"return": RETURN,
+	"if":       IF,
+	"elif":     ELIF,
+	"else":     ELSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"func":     FUNC,
+	"let":      LET,
+	"return":   RETURN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
+const (
+	ERROR   = "error"
+	WARNING = "warning"
+)
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
 	IDENTIFIER = "IDENTIFIER"
 	STRING     = "STRING"
-	INT        = "INT"
+
+	STRING_START = "STRING_START"
+	STRING_MID   = "STRING_MID"
+	STRING_END   = "STRING_END"
+	INT          = "INT"
+	FLOAT        = "FLOAT"
 
 	ADD = "ADD"
 	SUB = "SUB"
@@ -39,6 +162,14 @@ const (
 	DIV = "DIV"
 	MOD = "MOD"
 
+	ADD_EQ    = "ADD_EQ"
+	SUB_EQ    = "SUB_EQ"
+	MUL_EQ    = "MUL_EQ"
+	DIV_EQ    = "DIV_EQ"
+	MOD_EQ    = "MOD_EQ"
+	INCREMENT = "INCREMENT"
+	DECREMENT = "DECREMENT"
+
 	EE  = "EE"
 	EQ  = "EQ"
 	NOT = "NOT"
@@ -55,37 +186,62 @@ const (
 	QUESTION  = "QUESTION"
 	COLON     = "COLON"
 	COMMA     = "COMMA"
+	DOT       = "DOT"
 	SEMICOLON = "SEMICOLON"
 	ASSIGN    = "ASSIGN"
 
-	ARROW = "ARROW"
+	ARROW           = "ARROW"
+	INCLUSIVE_ARROW = "INCLUSIVE_ARROW"
+	FAT_ARROW       = "FAT_ARROW"
 
 	LPAREN = "LPAREN"
 	RPAREN = "RPAREN"
 	LBRACE = "LBRACE"
 	RBRACE = "RBRACE"
 
+	LBRACKET = "LBRACKET"
+	RBRACKET = "RBRACKET"
+
 	WHILE  = "WHILE"
 	FOR    = "FOR"
 	IF     = "IF"
+	ELIF   = "ELIF"
+	ELSE   = "ELSE"
 	FUNC   = "FUNC"
 	LET    = "LET"
 	RETURN = "RETURN"
 
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+
 	PROGRAM_NODE             = "PROGRAM_NODE"
 	BIN_OP_NODE              = "BIN_OP_NODE"
 	VAR_ACCESS_NODE          = "VAR_ACCESS_NODE"
 	INT_NODE                 = "INT_NODE"
+	FLOAT_NODE               = "FLOAT_NODE"
 	STRING_NODE              = "STRING_NODE"
+	INTERPOLATION_NODE       = "INTERPOLATION_NODE"
+	ARRAY_NODE               = "ARRAY_NODE"
+	INDEX_NODE               = "INDEX_NODE"
+	SLICE_NODE               = "SLICE_NODE"
+	MAP_NODE                 = "MAP_NODE"
+	MEMBER_NODE              = "MEMBER_NODE"
 	UNARY_NODE               = "UNARY_NODE"
+	CONDITIONAL_NODE         = "CONDITIONAL_NODE"
 	ERROR_NODE               = "ERROR_NODE"
 	FUNC_CALL_NODE           = "FUNC_CALL_NODE"
 	PARAMETER_NODE           = "PARAMETER_NODE"
 	ASSIGN_NODE              = "ASSIGN_NODE"
-	CONDITION_NODE           = "CONDITION_NODE"
+	UPDATE_NODE              = "UPDATE_NODE"
 	IF_NODE                  = "IF_NODE"
 	IF_CONDITION_NODE        = "IF_CONDITION_NODE"
 	FOR_NODE                 = "FOR_NODE"
+	FOR_IN_NODE              = "FOR_IN_NODE"
+	RANGE_NODE               = "RANGE_NODE"
 	WHILE_NODE               = "WHILE_NODE"
+	BREAK_NODE               = "BREAK_NODE"
+	CONTINUE_NODE            = "CONTINUE_NODE"
 	FUNCTION_DEFENITION_NODE = "FUNCTION_DEFENITION_NODE"
+	FUNCTION_NODE            = "FUNCTION_NODE"
+	ARROW_FUNCTION_NODE      = "ARROW_FUNCTION_NODE"
 )
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"strings"
 	"terminascript/evaluator"
@@ -12,18 +11,17 @@ import (
 	"terminascript/parser"
 )
 
-func ReadFile(filename string) string {
-	filePointer, _ := os.Open(filename)
-	fileBytes, _ := ioutil.ReadAll(filePointer)
-	return string(fileBytes)
-}
-
 func startRepl(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	e := evaluator.NewEnvironment()
+	program := ""
 
 	for {
-		fmt.Fprintf(out, ">>")
+		if program == "" {
+			fmt.Fprintf(out, ">>")
+		} else {
+			fmt.Fprintf(out, "..")
+		}
 		scanned := scanner.Scan()
 
 		if !scanned {
@@ -31,27 +29,75 @@ func startRepl(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
-		interpretProgram(line, e)
+		program += line + "\n"
+
+		// Keep reading lines while a brace, bracket or string is still open.
+		// A blank line runs whatever has been typed so far.
+		if strings.TrimSpace(line) != "" && isIncomplete(program) {
+			continue
+		}
+
+		interpretProgram("repl", strings.NewReader(program), e)
+		program = ""
 	}
 }
 
-func interpretProgram(program string, e *evaluator.Environment) {
-	l := lexer.NewLexer(strings.TrimSpace(program))
-	tokens := l.Lex()
+func isIncomplete(program string) bool {
+	l := lexer.NewLexer("repl", program)
+	l.Lex()
+	return l.Incomplete()
+}
 
-	p := parser.NewParser(tokens)
-	ast := p.Parse()
+// interpretProgram runs a program, reporting whether it ran without errors.
+// Tokens are read from the program as the parser needs them.
+func interpretProgram(filename string, program io.Reader, e *evaluator.Environment) bool {
+	l := lexer.NewReaderLexer(filename, program)
+	p := parser.NewStreamParser(l)
+	ast, diagnostics := p.Parse()
 
-	evaluator.Eval(ast, e)
+	// Syntax errors after a lexical error are usually caused by it, so only
+	// report the lexical errors when there are any.
+	if _, lexical := l.Lex(); len(lexical) > 0 {
+		diagnostics = lexical
+	}
+	if len(diagnostics) > 0 {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+		return false
+	}
+
+	if _, err := evaluator.Run(ast, e); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(formatCommand(os.Args[2:]))
+		case "tokens", "ast":
+			os.Exit(dumpCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	if len(os.Args) > 1 {
 		filename := os.Args[1]
-		file := ReadFile(filename)
-		formattedFile := strings.Replace(file, `\n`, ``, -1)
+		file, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
 		e := evaluator.NewEnvironment()
-		interpretProgram(formattedFile, e)
+		if !interpretProgram(filename, file, e) {
+			file.Close()
+			os.Exit(1)
+		}
 	} else {
 		startRepl(os.Stdin, os.Stdout)
 	}
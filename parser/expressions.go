@@ -0,0 +1,413 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"terminascript/lexer"
+)
+
+// Binding powers, from loosest to tightest.
+const (
+	_ int = iota
+	LOWEST
+	CONDITIONAL // c : a ? b
+	OR          // ||
+	AND         // &&
+	COMPARISON  // == != < > <= >=
+	SUM         // + -
+	PRODUCT     // * / %
+	PREFIX      // -x !x ++x --x
+	POSTFIX     // f(x) x[i] x.name x++ x--
+)
+
+type prefixParseFn func(p *Parser) Expression
+
+// infixParseFn continues an expression whose left operand, starting at
+// start, has already been parsed. Postfix operators are infix rules that
+// take no right operand.
+type infixParseFn func(p *Parser, left Expression, start lexer.Token) Expression
+
+type infixRule struct {
+	precedence       int
+	rightAssociative bool
+	parse            infixParseFn
+}
+
+var prefixRules map[string]prefixParseFn
+var infixRules map[string]infixRule
+
+func init() {
+	prefixRules = map[string]prefixParseFn{
+		lexer.IDENTIFIER:   parseVarAccess,
+		lexer.INT:          parseInt,
+		lexer.FLOAT:        parseFloat,
+		lexer.STRING:       parseString,
+		lexer.STRING_START: parseInterpolation,
+		lexer.LPAREN:       parseGroup,
+		lexer.LBRACKET:     parseArray,
+		lexer.LBRACE:       parseMap,
+		lexer.FUNC:         parseFunction,
+		lexer.SUB:          parseUnary,
+		lexer.NOT:          parseUnary,
+		lexer.INCREMENT:    parsePrefixUpdate,
+		lexer.DECREMENT:    parsePrefixUpdate,
+	}
+
+	infixRules = map[string]infixRule{
+		lexer.COLON: {CONDITIONAL, true, parseConditional},
+
+		lexer.OR:  {OR, false, parseBinary},
+		lexer.AND: {AND, false, parseBinary},
+
+		lexer.EE:  {COMPARISON, false, parseBinary},
+		lexer.NE:  {COMPARISON, false, parseBinary},
+		lexer.LT:  {COMPARISON, false, parseBinary},
+		lexer.GT:  {COMPARISON, false, parseBinary},
+		lexer.LTE: {COMPARISON, false, parseBinary},
+		lexer.GTE: {COMPARISON, false, parseBinary},
+
+		lexer.ADD: {SUM, false, parseBinary},
+		lexer.SUB: {SUM, false, parseBinary},
+
+		lexer.MUL: {PRODUCT, false, parseBinary},
+		lexer.DIV: {PRODUCT, false, parseBinary},
+		lexer.MOD: {PRODUCT, false, parseBinary},
+
+		lexer.LPAREN:    {POSTFIX, false, parseCall},
+		lexer.LBRACKET:  {POSTFIX, false, parseIndex},
+		lexer.DOT:       {POSTFIX, false, parseMember},
+		lexer.INCREMENT: {POSTFIX, false, parsePostfixUpdate},
+		lexer.DECREMENT: {POSTFIX, false, parsePostfixUpdate},
+	}
+}
+
+// Precedence returns the binding power of an infix or postfix operator, or
+// LOWEST for a token that does not continue an expression.
+func Precedence(tokenType string) int {
+	if rule, ok := infixRules[tokenType]; ok {
+		return rule.precedence
+	}
+	return LOWEST
+}
+
+// ParseExpression parses an expression made of operators that bind tighter
+// than precedence, leaving the parser on the token after it.
+func (p *Parser) ParseExpression(precedence int) Expression {
+	start := p.token
+	prefix, ok := prefixRules[p.token.Type]
+	if !ok {
+		return p.ReturnError("Unexpected "+p.token.Type, p.token)
+	}
+
+	left := prefix(p)
+	for {
+		rule, ok := infixRules[p.token.Type]
+		if !ok || rule.precedence <= precedence {
+			return left
+		}
+		left = rule.parse(p, left, start)
+	}
+}
+
+func parseBinary(p *Parser, left Expression, start lexer.Token) Expression {
+	op := p.token.Type
+	rule := infixRules[op]
+	p.advance()
+
+	precedence := rule.precedence
+	if rule.rightAssociative {
+		precedence--
+	}
+	right := p.ParseExpression(precedence)
+	return BinaryOperationNode{Type: lexer.BIN_OP_NODE, Left: left, Op: op, Right: right, Location: p.spanFrom(start)}
+}
+
+// parseConditional parses the rest of "condition : consequence ? alternate".
+// The consequence cannot itself be a conditional without parentheses, but
+// the alternate can, so conditionals chain to the right.
+func parseConditional(p *Parser, condition Expression, start lexer.Token) Expression {
+	p.advance()
+	consequence := p.ParseExpression(CONDITIONAL)
+
+	if p.token.Type != lexer.QUESTION {
+		return p.ReturnError("Expected QUESTION Conditional Expression", p.token)
+	}
+	p.advance()
+
+	alternate := p.ParseExpression(CONDITIONAL - 1)
+	return ConditionalNode{lexer.CONDITIONAL_NODE, condition, consequence, alternate, p.spanFrom(start)}
+}
+
+func parseUnary(p *Parser) Expression {
+	start := p.token
+	op := p.token.Type
+	p.advance()
+	right := p.ParseExpression(PREFIX)
+	return UnaryOpNode{lexer.UNARY_NODE, op, right, p.spanFrom(start)}
+}
+
+// updateOperators maps ++ and -- to the operator they apply.
+var updateOperators = map[string]string{
+	lexer.INCREMENT: lexer.ADD,
+	lexer.DECREMENT: lexer.SUB,
+}
+
+func parsePrefixUpdate(p *Parser) Expression {
+	start := p.token
+	p.advance()
+	target := p.ParseExpression(PREFIX)
+	if !IsAssignable(target) {
+		return p.ReturnError("Cannot "+lexer.Symbol(start.Type)+" "+target.String(), start)
+	}
+	return UpdateNode{lexer.UPDATE_NODE, updateOperators[start.Type], true, target, p.spanFrom(start)}
+}
+
+func parsePostfixUpdate(p *Parser, target Expression, start lexer.Token) Expression {
+	op := p.token
+	p.advance()
+	if !IsAssignable(target) {
+		return p.ReturnError("Cannot "+lexer.Symbol(op.Type)+" "+target.String(), op)
+	}
+	return UpdateNode{lexer.UPDATE_NODE, updateOperators[op.Type], false, target, p.spanFrom(start)}
+}
+
+func parseCall(p *Parser, function Expression, start lexer.Token) Expression {
+	parameters := p.ParseParameters()
+	if p.token.Type != lexer.RPAREN {
+		return ErrorNode{lexer.ERROR_NODE, p.spanFrom(start)}
+	}
+	p.advance()
+	return FunctionCallNode{lexer.FUNC_CALL_NODE, function, parameters, p.spanFrom(start)}
+}
+
+// parseFunction parses an anonymous function, "func (parameters) { ... }".
+func parseFunction(p *Parser) Expression {
+	start := p.token
+	p.advance()
+
+	if p.token.Type != lexer.LPAREN {
+		return p.ReturnError("Expected LPAREN Function", p.token)
+	}
+	parameters := p.ParseFunctionParameters()
+	if p.token.Type == lexer.RPAREN {
+		p.advance()
+	}
+
+	if p.token.Type != lexer.LBRACE {
+		return p.ReturnError("Expected LBRACE Function", p.token)
+	}
+	consequence := p.ParseFunctionBody()
+	return FunctionNode{lexer.FUNCTION_NODE, parameters, consequence, p.spanFrom(start)}
+}
+
+// isArrowFunction reports whether the LPAREN the parser is on starts the
+// parameters of an arrow function rather than a parenthesised expression,
+// by looking ahead for a list of names followed by RPAREN and FAT_ARROW.
+func (p *Parser) isArrowFunction() bool {
+	i := 1
+	if p.peekAhead(i).Type != lexer.RPAREN {
+		for p.peekAhead(i).Type == lexer.IDENTIFIER && p.peekAhead(i+1).Type == lexer.COMMA {
+			i += 2
+		}
+		if p.peekAhead(i).Type != lexer.IDENTIFIER {
+			return false
+		}
+		i++
+	}
+	return p.peekAhead(i).Type == lexer.RPAREN && p.peekAhead(i+1).Type == lexer.FAT_ARROW
+}
+
+// parseArrowFunction parses "(parameters) => body". The body extends as far
+// as an expression can, so "(x) => x * 2" returns x * 2.
+func parseArrowFunction(p *Parser) Expression {
+	start := p.token
+	parameters := p.ParseFunctionParameters()
+	p.advance()
+	p.advance()
+	body := p.ParseExpression(LOWEST)
+	return ArrowFunctionNode{lexer.ARROW_FUNCTION_NODE, parameters, body, p.spanFrom(start)}
+}
+
+// parseArray parses an array literal. A trailing comma is allowed.
+func parseArray(p *Parser) Expression {
+	start := p.token
+	p.advance()
+
+	elements := make([]Expression, 0)
+	for p.token.Type != lexer.RBRACKET {
+		elements = append(elements, p.ParseExpression(LOWEST))
+		if p.token.Type != lexer.COMMA {
+			break
+		}
+		p.advance()
+	}
+
+	if p.token.Type != lexer.RBRACKET {
+		return p.ReturnError("Expected COMMA or RBRACKET Array", p.token)
+	}
+	p.advance()
+	return ArrayNode{lexer.ARRAY_NODE, elements, p.spanFrom(start)}
+}
+
+// parseMap parses a map literal. Blocks are only parsed after the header of
+// a statement, so an LBRACE where an expression is expected starts a map.
+// Keys are expressions, parsed above conditional precedence so that the
+// COLON after them is not read as part of a conditional.
+func parseMap(p *Parser) Expression {
+	start := p.token
+	p.advance()
+
+	keys := make([]Expression, 0)
+	values := make([]Expression, 0)
+	for p.token.Type != lexer.RBRACE {
+		keys = append(keys, p.ParseExpression(CONDITIONAL))
+		if p.token.Type != lexer.COLON {
+			return p.ReturnError("Expected COLON Map", p.token)
+		}
+		p.advance()
+		values = append(values, p.ParseExpression(LOWEST))
+
+		if p.token.Type != lexer.COMMA {
+			break
+		}
+		p.advance()
+	}
+
+	if p.token.Type != lexer.RBRACE {
+		return p.ReturnError("Expected COMMA or RBRACE Map", p.token)
+	}
+	p.advance()
+	return MapNode{lexer.MAP_NODE, keys, values, p.spanFrom(start)}
+}
+
+func parseMember(p *Parser, left Expression, start lexer.Token) Expression {
+	p.advance()
+	if p.token.Type != lexer.IDENTIFIER {
+		return p.ReturnError("Expected IDENTIFIER after DOT", p.token)
+	}
+	name := p.token.Literal
+	p.advance()
+	return MemberNode{lexer.MEMBER_NODE, left, name, p.spanFrom(start)}
+}
+
+// parseIndex parses "[index]" or "[start:end]" after an expression. The
+// COLON of a slice would otherwise start a conditional, so a conditional
+// inside the brackets needs parentheses.
+func parseIndex(p *Parser, left Expression, start lexer.Token) Expression {
+	p.advance()
+
+	var index Expression
+	if p.token.Type != lexer.COLON {
+		index = p.ParseExpression(CONDITIONAL)
+	}
+
+	if p.token.Type == lexer.COLON {
+		p.advance()
+		var end Expression
+		if p.token.Type != lexer.RBRACKET {
+			end = p.ParseExpression(CONDITIONAL)
+		}
+		if p.token.Type != lexer.RBRACKET {
+			return p.ReturnError("Expected RBRACKET Slice", p.token)
+		}
+		p.advance()
+		return SliceNode{lexer.SLICE_NODE, left, index, end, p.spanFrom(start)}
+	}
+
+	if p.token.Type != lexer.RBRACKET {
+		return p.ReturnError("Expected RBRACKET Index", p.token)
+	}
+	p.advance()
+	return IndexNode{lexer.INDEX_NODE, left, index, p.spanFrom(start)}
+}
+
+func parseGroup(p *Parser) Expression {
+	if p.isArrowFunction() {
+		return parseArrowFunction(p)
+	}
+	p.advance()
+	expr := p.ParseExpression(LOWEST)
+	if p.token.Type != lexer.RPAREN {
+		return p.ReturnError("Expected RPAREN Expression", p.token)
+	}
+	p.advance()
+	return expr
+}
+
+func parseVarAccess(p *Parser) Expression {
+	start := p.token
+	p.advance()
+	return VarAccessNode{lexer.VAR_ACCESS_NODE, start.Literal, start.Span()}
+}
+
+func parseInt(p *Parser) Expression {
+	start := p.token
+	value, err := parseIntLiteral(start.Literal)
+	if err != nil {
+		return p.ReturnError("Integer literal "+start.Literal+" out of range", start)
+	}
+	p.advance()
+	return IntNode{lexer.INT_NODE, value, start.Span()}
+}
+
+func parseFloat(p *Parser) Expression {
+	start := p.token
+	value, err := strconv.ParseFloat(strings.ReplaceAll(start.Literal, "_", ""), 64)
+	if err != nil {
+		return p.ReturnError("Float literal "+start.Literal+" out of range", start)
+	}
+	p.advance()
+	return FloatNode{lexer.FLOAT_NODE, value, start.Span()}
+}
+
+func parseString(p *Parser) Expression {
+	start := p.token
+	p.advance()
+	return StringNode{lexer.STRING_NODE, start.Literal, start.Span()}
+}
+
+// parseInterpolation parses a string with embedded ${} expressions, from
+// its STRING_START token up to and including its STRING_END token.
+func parseInterpolation(p *Parser) Expression {
+	start := p.token
+	strs := []string{p.token.Literal}
+	var expressions []Expression
+
+	for p.token.Type != lexer.STRING_END {
+		p.advance()
+		if p.token.Type == lexer.STRING_MID || p.token.Type == lexer.STRING_END {
+			return p.ReturnError("Expected Expression String Interpolation", p.token)
+		}
+		expressions = append(expressions, p.ParseExpression(LOWEST))
+
+		if p.token.Type != lexer.STRING_MID && p.token.Type != lexer.STRING_END {
+			return p.ReturnError("Expected } String Interpolation", p.token)
+		}
+		strs = append(strs, p.token.Literal)
+	}
+	p.advance()
+
+	return InterpolationNode{lexer.INTERPOLATION_NODE, strs, expressions, p.spanFrom(start)}
+}
+
+// parseIntLiteral converts an INT literal, which may have a base prefix and
+// digit separators, to its value.
+func parseIntLiteral(literal string) (int, error) {
+	digits := strings.ReplaceAll(literal, "_", "")
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	value, err := strconv.ParseInt(digits, base, 0)
+	return int(value), err
+}
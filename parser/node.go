@@ -0,0 +1,220 @@
+package parser
+
+import "terminascript/lexer"
+
+func (n ProgramNode) Span() lexer.Span            { return n.Location }
+func (n ReturnNode) Span() lexer.Span             { return n.Location }
+func (n FunctionDefenitionNode) Span() lexer.Span { return n.Location }
+func (n FunctionNode) Span() lexer.Span           { return n.Location }
+func (n ArrowFunctionNode) Span() lexer.Span      { return n.Location }
+func (n ForNode) Span() lexer.Span                { return n.Location }
+func (n ForInNode) Span() lexer.Span              { return n.Location }
+func (n RangeNode) Span() lexer.Span              { return n.Location }
+func (n WhileNode) Span() lexer.Span              { return n.Location }
+func (n BreakNode) Span() lexer.Span              { return n.Location }
+func (n ContinueNode) Span() lexer.Span           { return n.Location }
+func (n IfNode) Span() lexer.Span                 { return n.Location }
+func (n IfConditionNode) Span() lexer.Span        { return n.Location }
+func (n FunctionCallNode) Span() lexer.Span       { return n.Location }
+func (n AssignmentNode) Span() lexer.Span         { return n.Location }
+func (n UpdateNode) Span() lexer.Span             { return n.Location }
+func (n ParameterNode) Span() lexer.Span          { return n.Location }
+func (n BinaryOperationNode) Span() lexer.Span    { return n.Location }
+func (n ConditionalNode) Span() lexer.Span        { return n.Location }
+func (n UnaryOpNode) Span() lexer.Span            { return n.Location }
+func (n VarAccessNode) Span() lexer.Span          { return n.Location }
+func (n IntNode) Span() lexer.Span                { return n.Location }
+func (n FloatNode) Span() lexer.Span              { return n.Location }
+func (n StringNode) Span() lexer.Span             { return n.Location }
+func (n InterpolationNode) Span() lexer.Span      { return n.Location }
+func (n ArrayNode) Span() lexer.Span              { return n.Location }
+func (n IndexNode) Span() lexer.Span              { return n.Location }
+func (n SliceNode) Span() lexer.Span              { return n.Location }
+func (n MapNode) Span() lexer.Span                { return n.Location }
+func (n MemberNode) Span() lexer.Span             { return n.Location }
+func (n ErrorNode) Span() lexer.Span              { return n.Location }
+
+func (FunctionCallNode) expressionNode()    {}
+func (FunctionNode) expressionNode()        {}
+func (ArrowFunctionNode) expressionNode()   {}
+func (BinaryOperationNode) expressionNode() {}
+func (ConditionalNode) expressionNode()     {}
+func (UnaryOpNode) expressionNode()         {}
+func (VarAccessNode) expressionNode()       {}
+func (IntNode) expressionNode()             {}
+func (FloatNode) expressionNode()           {}
+func (StringNode) expressionNode()          {}
+func (InterpolationNode) expressionNode()   {}
+func (RangeNode) expressionNode()           {}
+func (UpdateNode) expressionNode()          {}
+func (ArrayNode) expressionNode()           {}
+func (IndexNode) expressionNode()           {}
+func (SliceNode) expressionNode()           {}
+func (MapNode) expressionNode()             {}
+func (MemberNode) expressionNode()          {}
+func (ErrorNode) expressionNode()           {}
+
+func (ProgramNode) statementNode()            {}
+func (ReturnNode) statementNode()             {}
+func (FunctionDefenitionNode) statementNode() {}
+func (ForInNode) statementNode()              {}
+func (ForNode) statementNode()                {}
+func (WhileNode) statementNode()              {}
+func (BreakNode) statementNode()              {}
+func (ContinueNode) statementNode()           {}
+func (IfNode) statementNode()                 {}
+func (AssignmentNode) statementNode()         {}
+func (ErrorNode) statementNode()              {}
+
+func (n ProgramNode) Children() []Node {
+	return n.Expressions
+}
+
+func (n ReturnNode) Children() []Node {
+	if n.Expression == nil {
+		return nil
+	}
+	return []Node{n.Expression}
+}
+
+func (n FunctionDefenitionNode) Children() []Node {
+	return append(expressionNodes(n.Parameters), n.Consequence)
+}
+
+func (n FunctionNode) Children() []Node {
+	return append(expressionNodes(n.Parameters), n.Consequence)
+}
+
+func (n ArrowFunctionNode) Children() []Node {
+	return append(expressionNodes(n.Parameters), n.Body)
+}
+
+func (n ForNode) Children() []Node {
+	if n.Step == nil {
+		return []Node{n.MinValue, n.MaxValue, n.Consequence}
+	}
+	return []Node{n.MinValue, n.MaxValue, n.Step, n.Consequence}
+}
+
+func (n ForInNode) Children() []Node {
+	return []Node{n.Iterable, n.Consequence}
+}
+
+func (n RangeNode) Children() []Node {
+	if n.Step == nil {
+		return []Node{n.Start, n.End}
+	}
+	return []Node{n.Start, n.End, n.Step}
+}
+
+func (n WhileNode) Children() []Node {
+	return []Node{n.Condition, n.Consequence}
+}
+
+func (n BreakNode) Children() []Node { return nil }
+
+func (n ContinueNode) Children() []Node { return nil }
+
+// Children of an IfNode include the else branch only if there is one.
+func (n IfNode) Children() []Node {
+	var children []Node
+	for _, branch := range n.Cases {
+		children = append(children, branch)
+	}
+	if n.HasAlternate() {
+		children = append(children, n.Alternate)
+	}
+	return children
+}
+
+// HasAlternate reports whether the if statement has an else branch, which
+// may be empty.
+func (n IfNode) HasAlternate() bool {
+	return n.Alternate.Location != lexer.Span{}
+}
+
+func (n IfConditionNode) Children() []Node {
+	return []Node{n.Condition, n.Consequence}
+}
+
+func (n FunctionCallNode) Children() []Node {
+	return append([]Node{n.Function}, expressionNodes(n.Parameters)...)
+}
+
+func (n AssignmentNode) Children() []Node {
+	if n.Value == nil {
+		return []Node{n.Target}
+	}
+	return []Node{n.Target, n.Value}
+}
+
+func (n UpdateNode) Children() []Node {
+	return []Node{n.Target}
+}
+
+func (n ParameterNode) Children() []Node { return nil }
+
+func (n BinaryOperationNode) Children() []Node {
+	return []Node{n.Left, n.Right}
+}
+
+func (n ConditionalNode) Children() []Node {
+	return []Node{n.Condition, n.Consequence, n.Alternate}
+}
+
+func (n UnaryOpNode) Children() []Node {
+	return []Node{n.Right}
+}
+
+func (n VarAccessNode) Children() []Node { return nil }
+
+func (n IntNode) Children() []Node { return nil }
+
+func (n FloatNode) Children() []Node { return nil }
+
+func (n StringNode) Children() []Node { return nil }
+
+func (n InterpolationNode) Children() []Node {
+	return expressionNodes(n.Expressions)
+}
+
+func (n ArrayNode) Children() []Node {
+	return expressionNodes(n.Elements)
+}
+
+func (n IndexNode) Children() []Node {
+	return []Node{n.Left, n.Index}
+}
+
+func (n SliceNode) Children() []Node {
+	children := []Node{n.Left}
+	if n.Start != nil {
+		children = append(children, n.Start)
+	}
+	if n.End != nil {
+		children = append(children, n.End)
+	}
+	return children
+}
+
+func (n MapNode) Children() []Node {
+	children := make([]Node, 0, 2*len(n.Keys))
+	for i := range n.Keys {
+		children = append(children, n.Keys[i], n.Values[i])
+	}
+	return children
+}
+
+func (n MemberNode) Children() []Node {
+	return []Node{n.Left}
+}
+
+func (n ErrorNode) Children() []Node { return nil }
+
+func expressionNodes(expressions []Expression) []Node {
+	nodes := make([]Node, 0, len(expressions))
+	for _, expression := range expressions {
+		nodes = append(nodes, expression)
+	}
+	return nodes
+}
@@ -1,100 +1,283 @@
 package parser
 
+import "terminascript/lexer"
+
+// Node is implemented by every node of the syntax tree.
+type Node interface {
+	// Span is the source the node was parsed from.
+	Span() lexer.Span
+	// String formats the node as source code on a single line, with binary
+	// and conditional expressions fully parenthesised.
+	String() string
+	// Children are the nodes directly below this one, in source order.
+	Children() []Node
+}
+
+// Expression is a node that produces a value.
+type Expression interface {
+	Node
+	expressionNode()
+}
+
+// Statement is a node that is run for its effect. Expressions can be used
+// as statements too, so a block holds Nodes rather than Statements.
+type Statement interface {
+	Node
+	statementNode()
+}
+
+// ProgramNode is a whole program or the body of a block.
 type ProgramNode struct {
 	Type        string
-	Expressions []interface{}
+	Expressions []Node
+	Location    lexer.Span
 }
 
 type ReturnNode struct {
 	Type       string
-	Expression interface{}
+	Expression Expression
+	Location   lexer.Span
 }
 
 type FunctionDefenitionNode struct {
 	Type        string
 	Identifier  string
-	Parameters  []interface{}
+	Parameters  []Expression
 	Consequence ProgramNode
+	Location    lexer.Span
 }
 
-type ForNode struct {
+// FunctionNode is an anonymous function, as in "func (x) { return x; }".
+type FunctionNode struct {
 	Type        string
-	Identifier  string
-	MinValue    interface{}
-	MaxValue    interface{}
+	Parameters  []Expression
 	Consequence ProgramNode
+	Location    lexer.Span
 }
 
-type WhileNode struct {
+// ArrowFunctionNode is an anonymous function that returns the value of a
+// single expression, as in "(x) => x * 2".
+type ArrowFunctionNode struct {
+	Type       string
+	Parameters []Expression
+	Body       Expression
+	Location   lexer.Span
+}
+
+// ForNode and WhileNode have an empty Label unless the loop is written with
+// one, as in "outer: for (...)". A ForNode counts from MinValue towards
+// MaxValue, stopping before it unless the loop is Inclusive. Step is nil if
+// the loop has no step clause.
+type ForNode struct {
 	Type        string
-	Condition   []ConditionNode
+	Label       string
+	Identifier  string
+	MinValue    Expression
+	MaxValue    Expression
+	Step        Expression
+	Inclusive   bool
 	Consequence ProgramNode
+	Location    lexer.Span
 }
 
-type IfNode struct {
+// ForInNode loops over the elements of Iterable, which may be a RangeNode.
+// Key is empty unless the loop names two variables, as in "for (i, ch in s)".
+type ForInNode struct {
 	Type        string
-	Condition   []ConditionNode
+	Label       string
+	Key         string
+	Value       string
+	Iterable    Expression
 	Consequence ProgramNode
-	Alternate   ProgramNode
+	Location    lexer.Span
 }
 
-type IfConditionNode struct {
+// RangeNode is a range of ints written "a -> b step c". It is only parsed
+// as the Iterable of a ForInNode.
+type RangeNode struct {
+	Type      string
+	Start     Expression
+	End       Expression
+	Step      Expression
+	Inclusive bool
+	Location  lexer.Span
+}
+
+type WhileNode struct {
 	Type        string
-	Condition   ConditionNode
+	Label       string
+	Condition   Expression
 	Consequence ProgramNode
+	Location    lexer.Span
 }
 
-type ConditionNode struct {
+// BreakNode leaves the innermost loop, or the loop named by Label.
+type BreakNode struct {
+	Type     string
+	Label    string
+	Location lexer.Span
+}
+
+// ContinueNode starts the next iteration of the innermost loop, or of the
+// loop named by Label.
+type ContinueNode struct {
+	Type     string
+	Label    string
+	Location lexer.Span
+}
+
+// IfNode is an if statement with any elif branches in Cases, tried in
+// order, and the else branch in Alternate.
+type IfNode struct {
 	Type      string
-	Seperator string
-	Condition interface{}
+	Cases     []IfConditionNode
+	Alternate ProgramNode
+	Location  lexer.Span
 }
 
+type IfConditionNode struct {
+	Type        string
+	Condition   Expression
+	Consequence ProgramNode
+	Location    lexer.Span
+}
+
+// FunctionCallNode calls the value of Function, which is usually a
+// VarAccessNode naming the function.
 type FunctionCallNode struct {
 	Type       string
-	Identifier string
-	Parameters []interface{}
+	Function   Expression
+	Parameters []Expression
+	Location   lexer.Span
 }
 
+// AssignmentNode is a declaration when it starts with let, in which case
+// Target is a VarAccessNode. Value is nil for a declaration without one, such
+// as "let z;". Op is the operator of a compound assignment such as "x += 1",
+// and empty otherwise.
 type AssignmentNode struct {
-	Type       string
-	Identifier string
-	Value      interface{}
+	Type        string
+	Target      Expression
+	Declaration bool
+	Op          string
+	Value       Expression
+	Location    lexer.Span
+}
+
+// UpdateNode is "x++", "x--", "++x" or "--x". Op is ADD or SUB.
+type UpdateNode struct {
+	Type     string
+	Op       string
+	Prefix   bool
+	Target   Expression
+	Location lexer.Span
 }
 
 type ParameterNode struct {
 	Type       string
 	Identifier string
+	Location   lexer.Span
 }
 
 type BinaryOperationNode struct {
-	Type  string
-	Left  interface{}
-	Op    string
-	Right interface{}
+	Type     string
+	Left     Expression
+	Op       string
+	Right    Expression
+	Location lexer.Span
+}
+
+// ConditionalNode is written "condition : consequence ? alternate".
+type ConditionalNode struct {
+	Type        string
+	Condition   Expression
+	Consequence Expression
+	Alternate   Expression
+	Location    lexer.Span
 }
 
 type UnaryOpNode struct {
-	Type  string
-	Op    string
-	Right interface{}
+	Type     string
+	Op       string
+	Right    Expression
+	Location lexer.Span
 }
 
 type VarAccessNode struct {
 	Type       string
 	Identifier string
+	Location   lexer.Span
 }
 
 type IntNode struct {
-	Type  string
-	Value int
+	Type     string
+	Value    int
+	Location lexer.Span
+}
+
+type FloatNode struct {
+	Type     string
+	Value    float64
+	Location lexer.Span
 }
 
 type StringNode struct {
-	Type  string
-	Value string
+	Type     string
+	Value    string
+	Location lexer.Span
+}
+
+// InterpolationNode is a string with embedded expressions. Strings holds the
+// literal text around the expressions, so it has one more element than
+// Expressions.
+type InterpolationNode struct {
+	Type        string
+	Strings     []string
+	Expressions []Expression
+	Location    lexer.Span
+}
+
+type ArrayNode struct {
+	Type     string
+	Elements []Expression
+	Location lexer.Span
+}
+
+// IndexNode is "left[index]".
+type IndexNode struct {
+	Type     string
+	Left     Expression
+	Index    Expression
+	Location lexer.Span
+}
+
+// SliceNode is "left[start:end]". Start and End are nil when left out.
+type SliceNode struct {
+	Type     string
+	Left     Expression
+	Start    Expression
+	End      Expression
+	Location lexer.Span
+}
+
+// MapNode is a map literal. The key at each position in Keys goes with the
+// value at the same position in Values.
+type MapNode struct {
+	Type     string
+	Keys     []Expression
+	Values   []Expression
+	Location lexer.Span
+}
+
+// MemberNode is "left.name", which is short for left["name"].
+type MemberNode struct {
+	Type     string
+	Left     Expression
+	Name     string
+	Location lexer.Span
 }
 
 type ErrorNode struct {
-	Type string
+	Type     string
+	Location lexer.Span
 }
@@ -1,45 +1,150 @@
 package parser
 
 import (
-	"fmt"
-	"strconv"
+	"strings"
 	"terminascript/lexer"
 )
 
-// TODO : if ... elif ... else statements
+// TokenSource supplies tokens to the parser one at a time. A *lexer.Lexer
+// is a TokenSource, so a program can be parsed while it is being read.
+type TokenSource interface {
+	NextToken() lexer.Token
+}
+
+// tokenList is a TokenSource over tokens that have already been lexed.
+type tokenList struct {
+	tokens   []lexer.Token
+	position int
+}
+
+func (t *tokenList) NextToken() lexer.Token {
+	if t.position >= len(t.tokens) {
+		if len(t.tokens) == 0 {
+			return lexer.Token{Type: lexer.EOF}
+		}
+		return t.tokens[len(t.tokens)-1]
+	}
+	t.position++
+	return t.tokens[t.position-1]
+}
 
 type Parser struct {
-	tokens       []lexer.Token
-	position     int
-	readPosition int
-	token        lexer.Token
+	source   TokenSource
+	token    lexer.Token
+	previous lexer.Token
+	peeked   []lexer.Token
+	halted   bool
+
+	diagnostics []lexer.Diagnostic
+	// panicking is set after a syntax error until the parser resynchronises,
+	// so one mistake is not reported many times over.
+	panicking bool
+	// braces is the number of LBRACE tokens passed that have not been
+	// closed, which lets synchronize tell the braces of the statement with
+	// an error from those around it.
+	braces int
+
+	// loops holds the labels of the loops around the statement being parsed,
+	// innermost last, with "" for a loop without a label.
+	loops []string
+}
+
+// ReturnError records a syntax error at token and returns an ErrorNode to
+// stand in for the node that could not be parsed.
+func (p *Parser) ReturnError(errorString string, token lexer.Token) ErrorNode {
+	p.report(errorString, token)
+	p.panicking = true
+	return ErrorNode{lexer.ERROR_NODE, token.Span()}
+}
+
+// report records a syntax error at token, unless the parser is already
+// skipping the rest of a statement with an error.
+func (p *Parser) report(errorString string, token lexer.Token) {
+	if !p.panicking {
+		p.diagnostics = append(p.diagnostics, lexer.Diagnostic{Message: errorString, Location: token.Span(), Severity: lexer.ERROR})
+	}
 }
 
-func ReturnError(errorString string) ErrorNode{
-	fmt.Println(errorString)
-	return ErrorNode{lexer.ERROR_NODE}
+// synchronize skips the rest of a statement that had a syntax error. It
+// stops after the next ; or after a {} block, or before the } that closes
+// the enclosing block. braces is the number of open braces when the
+// statement started; braces the statement opened before the error, such as
+// that of a map, are closed before it can stop.
+func (p *Parser) synchronize(braces int) {
+	depth := p.braces - braces
+	opened := depth > 0
+	for p.token.Type != lexer.EOF {
+		switch p.token.Type {
+		case lexer.SEMICOLON:
+			if depth == 0 {
+				p.advance()
+				p.panicking = false
+				return
+			}
+		case lexer.LBRACE:
+			depth++
+		case lexer.RBRACE:
+			if depth == 0 {
+				p.panicking = false
+				return
+			}
+			depth--
+			if depth == 0 && !opened {
+				p.advance()
+				p.panicking = false
+				return
+			}
+		}
+		p.advance()
+	}
+	p.panicking = false
 }
 
 func NewParser(tokens []lexer.Token) *Parser {
-	p := &Parser{tokens: tokens}
+	return NewStreamParser(&tokenList{tokens: tokens})
+}
+
+// NewStreamParser returns a parser that pulls tokens from source as it
+// needs them.
+func NewStreamParser(source TokenSource) *Parser {
+	p := &Parser{source: source}
 	p.advance()
 	return p
 }
 
 func (p *Parser) advance() {
-	if p.readPosition >= len(p.tokens) {
-		p.token = lexer.Token{}
+	switch p.token.Type {
+	case lexer.LBRACE:
+		p.braces++
+	case lexer.RBRACE:
+		p.braces--
+	}
+	p.previous = p.token
+	if len(p.peeked) > 0 {
+		p.token = p.peeked[0]
+		p.peeked = p.peeked[1:]
 	} else {
-		p.token = p.tokens[p.readPosition]
+		p.token = p.nextToken()
 	}
+}
 
-	p.position = p.readPosition
-	p.readPosition++
+// nextToken reads a token from the source. The lexer reports ILLEGAL tokens
+// itself, so parsing stops at the first one instead of guessing.
+func (p *Parser) nextToken() lexer.Token {
+	if p.halted {
+		return lexer.Token{Type: lexer.EOF, Pos: p.token.End, End: p.token.End}
+	}
+	tok := p.source.NextToken()
+	if tok.Type == lexer.ILLEGAL {
+		p.halted = true
+		return lexer.Token{Type: lexer.EOF, Pos: tok.Pos, End: tok.Pos}
+	}
+	return tok
 }
 
 func Includes(array []string, element string) bool {
-	for _,e := range array {
-		if e == element{
+	for _, e := range array {
+		if e == element {
 			return true
 		}
 	}
@@ -47,84 +152,155 @@ func Includes(array []string, element string) bool {
 }
 
 func (p *Parser) peekToken() lexer.Token {
-	if p.readPosition >= len(p.tokens) {
-		return lexer.Token{}
-	} else {
-		return p.tokens[p.readPosition]
+	return p.peekAhead(1)
+}
+
+// peekAhead returns the nth token after the current one without consuming
+// anything.
+func (p *Parser) peekAhead(n int) lexer.Token {
+	for len(p.peeked) < n {
+		p.peeked = append(p.peeked, p.nextToken())
 	}
+	return p.peeked[n-1]
 }
 
-func (p *Parser) Parse() ProgramNode {
-	var ast = ProgramNode{lexer.PROGRAM_NODE, make([]interface{}, 0)}
+// spanTo covers the tokens from start up to and including the current token.
+func (p *Parser) spanTo(start lexer.Token) lexer.Span {
+	return lexer.Span{Start: start.Pos, End: p.token.End}
+}
 
-	for (p.token.Type != lexer.EOF && p.token != lexer.Token{}) {
-		node := p.ParseExpr()
-		ast.Expressions = append(ast.Expressions, node)
-		p.advance()
+// spanFrom covers the tokens from start up to the token before the current one.
+func (p *Parser) spanFrom(start lexer.Token) lexer.Span {
+	return lexer.Span{Start: start.Pos, End: p.previous.End}
+}
+
+// Parse parses the whole program. Syntax errors are returned as diagnostics
+// rather than stopping the parse, so one run reports every error; it is up
+// to the caller whether a program with errors should be run.
+func (p *Parser) Parse() (ProgramNode, []lexer.Diagnostic) {
+	var ast = ProgramNode{lexer.PROGRAM_NODE, make([]Node, 0), lexer.Span{}}
+	start := p.token
+
+	for p.token.Type != lexer.EOF {
+		if p.token.Type == lexer.SEMICOLON {
+			p.advance()
+			continue
+		}
+		ast.Expressions = append(ast.Expressions, p.ParseExpr())
 	}
 
-	return ast
+	ast.Location = p.spanFrom(start)
+	return ast, p.diagnostics
 }
 
-func (p *Parser) ParseExpr() interface{} {
+// ParseExpr parses one statement, leaving the parser on the token after it.
+func (p *Parser) ParseExpr() Node {
+	start, braces := p.token, p.braces
+	node := p.parseStatement()
+	if p.panicking {
+		p.synchronize(braces)
+	}
+	if p.token.Pos == start.Pos && p.token.Type != lexer.EOF {
+		// Make progress past a token that cannot start a statement.
+		p.advance()
+	}
+	return node
+}
+
+func (p *Parser) parseStatement() Node {
 	switch p.token.Type {
 	case lexer.LET:
-		p.advance()
-		return p.ParseAssignment()
+		return p.ParseDeclaration()
 	case lexer.RETURN:
 		return p.ParseReturn()
 	case lexer.IF:
 		return p.ParseIf()
 	case lexer.WHILE:
-		return p.ParseWhile()
+		return p.ParseWhile("", p.token)
 	case lexer.FOR:
-		return p.ParseFor()
+		return p.ParseFor("", p.token)
 	case lexer.FUNC:
-		return p.ParseFunction()
+		// Without a name, func starts an anonymous function.
+		if p.peekToken().Type == lexer.IDENTIFIER {
+			return p.ParseFunction()
+		}
+		return p.ParseExpressionStatement()
+	case lexer.BREAK, lexer.CONTINUE:
+		return p.ParseLoopControl()
 	default:
-		if p.token.Type == lexer.IDENTIFIER {
-			if p.peekToken().Type == lexer.EQ || p.peekToken().Type == lexer.ASSIGN {
-				return p.ParseAssignment()
+		if p.token.Type == lexer.IDENTIFIER && p.peekToken().Type == lexer.COLON {
+			if next := p.peekAhead(2).Type; next == lexer.FOR || next == lexer.WHILE {
+				return p.ParseLabeledLoop()
 			}
 		}
-		return p.ParseComparison()
+		return p.ParseExpressionStatement()
 	}
 }
 
+// ParseExpressionStatement parses an expression or an assignment to one.
+func (p *Parser) ParseExpressionStatement() Node {
+	start := p.token
+	expr := p.ParseExpression(LOWEST)
+	if _, ok := assignmentOperators[p.token.Type]; ok {
+		return p.ParseAssignment(start, expr)
+	}
+	p.expectSemicolon()
+	return expr
+}
 
-func (p *Parser) ParseConditions() []ConditionNode {
-	var conditions []ConditionNode
-	var seperators = []string{lexer.AND,lexer.OR}
+// expectSemicolon ends a simple statement. The semicolon may be left out
+// before a } or at the end of the program. Reaching the end of a statement
+// after a syntax error means the parser is back in step. A missing semicolon
+// before a token that can start a statement is reported without skipping
+// ahead, since the next statement most likely starts there. Any other token
+// is part of the same mistake, so the rest of the statement is skipped.
+func (p *Parser) expectSemicolon() {
+	switch p.token.Type {
+	case lexer.SEMICOLON:
+		p.advance()
+		p.panicking = false
+	case lexer.RBRACE, lexer.EOF:
+	default:
+		end := lexer.Token{Pos: p.previous.End, End: p.previous.End}
+		if startsStatement(p.token.Type) {
+			p.report("Expected SEMICOLON after Statement", end)
+		} else {
+			p.ReturnError("Expected SEMICOLON after Statement", end)
+		}
+	}
+}
 
-	if p.token.Type != lexer.LPAREN { return nil }
-	p.advance()
+// startsStatement reports whether a token of type tokenType can be the first
+// token of a statement.
+func startsStatement(tokenType string) bool {
+	switch tokenType {
+	case lexer.LET, lexer.RETURN, lexer.IF, lexer.WHILE, lexer.FOR, lexer.FUNC, lexer.BREAK, lexer.CONTINUE:
+		return true
+	}
+	_, ok := prefixRules[tokenType]
+	return ok
+}
 
-	if p.token.Type == lexer.RPAREN { return conditions }
-	conditions = append(conditions, ConditionNode{lexer.CONDITION_NODE,"AND",p.ParseComparison()})
-	
-	currentSeperator := "AND"
-	if (p.token != lexer.Token{}) {
-		for (p.token != lexer.Token{} && p.token.Type != lexer.RPAREN && p.token.Type != lexer.SEMICOLON) {
-			isSeperator := Includes(seperators,p.token.Type)
-			if !isSeperator {
-				conditions = append(conditions, ConditionNode{lexer.CONDITION_NODE,currentSeperator,p.ParseComparison()})
-			} else {
-				currentSeperator = p.token.Type
-				p.advance()
-			}
-		}
+// ParseCondition parses the parenthesised condition of an if or while
+// statement, leaving the parser on the closing RPAREN.
+func (p *Parser) ParseCondition() Expression {
+	if p.token.Type != lexer.LPAREN {
+		return p.ReturnError("Expected LPAREN Condition", p.token)
 	}
+	p.advance()
 
-	return conditions
+	condition := p.ParseExpression(LOWEST)
+	if p.token.Type != lexer.RPAREN {
+		return p.ReturnError("Expected RPAREN Condition", p.token)
+	}
+	return condition
 }
 
-func (p *Parser) ParseMultiline() []interface{} {
-	var nodes []interface{}
-	for (p.token.Type != lexer.RBRACE) {
+func (p *Parser) ParseMultiline() []Node {
+	var nodes []Node
+	for p.token.Type != lexer.RBRACE && p.token.Type != lexer.EOF {
 		if p.token.Type != lexer.SEMICOLON {
-			expr := p.ParseExpr()
-			p.advance()
-			nodes = append(nodes,expr)
+			nodes = append(nodes, p.ParseExpr())
 		} else {
 			p.advance()
 		}
@@ -132,198 +308,375 @@ func (p *Parser) ParseMultiline() []interface{} {
 	return nodes
 }
 
-func (p *Parser) ParseAssignment() interface{} {
-	if p.token.Type != lexer.IDENTIFIER { return nil }
-	identifier := p.token.Literal
+// ParseDeclaration parses "let x := value;", or "let x;" without a value.
+func (p *Parser) ParseDeclaration() Node {
+	start := p.token
+	p.advance()
+
+	if p.token.Type != lexer.IDENTIFIER {
+		return p.ReturnError("Expected IDENTIFIER Variable Assignment", p.token)
+	}
+	target := VarAccessNode{lexer.VAR_ACCESS_NODE, p.token.Literal, p.token.Span()}
 
 	p.advance()
 	if p.token.Type != lexer.EQ && p.token.Type != lexer.ASSIGN {
 		if p.token.Type == lexer.SEMICOLON {
-			return AssignmentNode{lexer.ASSIGN_NODE, identifier, IntNode{lexer.INT_NODE,0}}
+			node := AssignmentNode{lexer.ASSIGN_NODE, target, true, "", nil, p.spanFrom(start)}
+			p.advance()
+			return node
 		}
-		return ReturnError("Expected ASSIGNMENT or EQ Variable Assignment")
+		return p.ReturnError("Expected ASSIGNMENT or EQ Variable Assignment", p.token)
 	}
 
 	p.advance()
-	return AssignmentNode{lexer.ASSIGN_NODE, identifier, p.ParseComparison()}
+	value := p.ParseExpression(LOWEST)
+	node := AssignmentNode{lexer.ASSIGN_NODE, target, true, "", value, p.spanFrom(start)}
+	p.expectSemicolon()
+	return node
 }
 
-func (p *Parser) ParseComparison() interface{} {
-	leftNode := p.ParseArith()
-	if p.token.Type != lexer.SEMICOLON && p.token.Type != lexer.EOF {
-		var operations = []string{lexer.EE,lexer.NE,lexer.GT,lexer.GTE,lexer.LT,lexer.LTE}
-
-		if Includes(operations,p.token.Type){
-			op := p.token.Type
-			p.advance()
-			return BinaryOperationNode{Type: lexer.BIN_OP_NODE, Left: leftNode, Op: op, Right: p.ParseComparison()}
-		}
-	}
-	return leftNode
+// assignmentOperators maps each assignment token to the operator a compound
+// assignment applies, or "" for plain assignment.
+var assignmentOperators = map[string]string{
+	lexer.EQ:     "",
+	lexer.ASSIGN: "",
+	lexer.ADD_EQ: lexer.ADD,
+	lexer.SUB_EQ: lexer.SUB,
+	lexer.MUL_EQ: lexer.MUL,
+	lexer.DIV_EQ: lexer.DIV,
+	lexer.MOD_EQ: lexer.MOD,
 }
 
-func (p *Parser) ParseArith() interface{} {
-	leftNode := p.ParseTerm()
-	if p.token.Type != lexer.SEMICOLON && p.token.Type != lexer.EOF {
-		var operations = []string{lexer.ADD,lexer.SUB,lexer.MOD}
+// ParseAssignment parses the rest of an assignment to target, which started
+// at start and was parsed as an expression.
+func (p *Parser) ParseAssignment(start lexer.Token, target Expression) Node {
+	op := assignmentOperators[p.token.Type]
+	if !IsAssignable(target) {
+		return p.ReturnError("Cannot assign to "+target.String(), lexer.Token{Pos: target.Span().Start, End: target.Span().End})
+	}
+	p.advance()
 
-		if Includes(operations,p.token.Type){
-			op := p.token.Type
-			p.advance()
-			return BinaryOperationNode{Type: lexer.BIN_OP_NODE, Left: leftNode, Op: op, Right: p.ParseArith()}
-		}
+	value := p.ParseExpression(LOWEST)
+	node := AssignmentNode{lexer.ASSIGN_NODE, target, false, op, value, p.spanFrom(start)}
+	p.expectSemicolon()
+	return node
+}
 
+// IsAssignable reports whether an expression can be assigned to.
+func IsAssignable(target Expression) bool {
+	switch target.(type) {
+	case VarAccessNode, IndexNode, MemberNode:
+		return true
 	}
-	return leftNode
+	return false
 }
 
-func (p *Parser) ParseTerm() interface{} {
-	leftNode := p.ParseFactor()
-	if p.token.Type != lexer.SEMICOLON && p.token.Type != lexer.EOF {
-		p.advance()
-		var operations = []string{lexer.MUL,lexer.DIV}
+// ParseParameters parses a parenthesised, comma separated list of
+// expressions, leaving the parser on the closing RPAREN.
+func (p *Parser) ParseParameters() []Expression {
+	parameters := make([]Expression, 0)
+	p.advance()
 
-		if Includes(operations,p.token.Type){
-			op := p.token.Type
-			p.advance()
-			return BinaryOperationNode{Type: lexer.BIN_OP_NODE, Left: leftNode, Op: op, Right: p.ParseTerm()}
+	if p.token.Type == lexer.RPAREN {
+		return parameters
+	}
+
+	for {
+		parameters = append(parameters, p.ParseExpression(LOWEST))
+		if p.token.Type != lexer.COMMA {
+			break
 		}
+		p.advance()
+	}
 
+	if p.token.Type != lexer.RPAREN {
+		p.ReturnError("Expected COMMA or RPAREN Parameters", p.token)
 	}
-	return leftNode
+	return parameters
 }
 
-func (p *Parser) ParseFactor() interface{} {
-	for p.token.Type != lexer.EOF && p.token.Type != lexer.SEMICOLON {
-		switch p.token.Type{
-		case lexer.IDENTIFIER:
-			ID := p.token.Literal
-
-			if p.peekToken().Type == lexer.LPAREN {
-				p.advance()
-				parameters := p.ParseParameters()
-				return FunctionCallNode{lexer.FUNC_CALL_NODE, ID, parameters}
-
-			} else {
-				return VarAccessNode{lexer.VAR_ACCESS_NODE, ID}
-			}
-
-		case lexer.INT:
-			intValue, _ := strconv.Atoi(p.token.Literal)
-			return IntNode{lexer.INT_NODE, intValue}
-
-		case lexer.STRING:
-			return StringNode{lexer.STRING_NODE, p.token.Literal}
-
-		case lexer.LPAREN:
-			p.advance()
-			expr := p.ParseComparison()
-			if p.token.Type == lexer.RPAREN {
-				return expr
-			}
+func (p *Parser) ParseReturn() Node {
+	start := p.token
+	p.advance()
 
-		case lexer.SUB:
-			p.advance()
-			return UnaryOpNode{lexer.UNARY_NODE, lexer.SUB, p.ParseFactor()}
-		
-		case lexer.NOT:
-			p.advance()
-			return UnaryOpNode{lexer.UNARY_NODE, lexer.NOT, p.ParseFactor()}
-		}
+	var expr Expression
+	if p.token.Type != lexer.SEMICOLON && p.token.Type != lexer.RBRACE && p.token.Type != lexer.EOF {
+		expr = p.ParseExpression(LOWEST)
 	}
-	return ErrorNode{}
+	node := ReturnNode{lexer.RETURN, expr, p.spanFrom(start)}
+	p.expectSemicolon()
+	return node
 }
 
-func (p *Parser) ParseParameters() []interface{} {
-	parameters := make([]interface{}, 0)
+func (p *Parser) ParseFunction() Node {
+	start := p.token
+	p.advance()
+
+	if p.token.Type != lexer.IDENTIFIER {
+		return p.ReturnError("Expected Identifier Function Defenition", p.token)
+	}
+	identifier := p.token.Literal
 	p.advance()
 
+	if p.token.Type != lexer.LPAREN {
+		return p.ReturnError("Expected LPAREN Function Defenition", p.token)
+	}
+	parameters := p.ParseFunctionParameters()
 	if p.token.Type == lexer.RPAREN {
-		return parameters
+		p.advance()
 	}
 
-	for (p.token != lexer.Token{} && p.token.Type != lexer.RPAREN && p.token.Type != lexer.SEMICOLON) {
-		if p.token.Type != lexer.COMMA {
-			parameters = append(parameters, p.ParseExpr())
-		} else {
-			p.advance()
+	if p.token.Type != lexer.LBRACE {
+		return p.ReturnError("Expected LBRACE Function Defenition", p.token)
+	}
+	consequence := p.ParseFunctionBody()
+	return FunctionDefenitionNode{lexer.FUNCTION_DEFENITION_NODE, identifier, parameters, consequence, p.spanFrom(start)}
+}
+
+// ParseFunctionParameters parses the parameter list of a function, which
+// must be names, leaving the parser on the closing RPAREN.
+func (p *Parser) ParseFunctionParameters() []Expression {
+	parameters := p.ParseParameters()
+	for _, parameter := range parameters {
+		if _, ok := parameter.(VarAccessNode); !ok {
+			p.ReturnError("Expected Identifier Parameter", lexer.Token{Pos: parameter.Span().Start, End: parameter.Span().End})
 		}
 	}
 	return parameters
 }
 
-func (p *Parser) ParseReturn() interface{} {
-	p.advance()
-	return ReturnNode{lexer.RETURN, p.ParseComparison()}
+// ParseFunctionBody parses the block of a function. break and continue
+// cannot reach loops outside the function.
+func (p *Parser) ParseFunctionBody() ProgramNode {
+	loops := p.loops
+	p.loops = nil
+	consequence := p.ParseBlock()
+	p.loops = loops
+	return consequence
 }
 
-func (p *Parser) ParseFunction() interface{} {
+// ParseLabeledLoop parses a loop preceded by a label, as in "outer: for".
+func (p *Parser) ParseLabeledLoop() Node {
+	start := p.token
+	label := p.token.Literal
 	p.advance()
-	
-	if p.token.Type != lexer.IDENTIFIER { return ReturnError("Expected Identifier Function Defenition")}
-	identifier := p.token.Literal
 	p.advance()
 
-	if p.token.Type != lexer.LPAREN { return ReturnError("Expected LPAREN Function Defenition")}
-	parameters := p.ParseParameters()
-	p.advance()
+	if p.token.Type == lexer.WHILE {
+		return p.ParseWhile(label, start)
+	}
+	return p.ParseFor(label, start)
+}
 
-	if p.token.Type != lexer.LBRACE { return ReturnError("Expected RBRACE Function Defenition") }
+// ParseLoopControl parses a break or continue statement with an optional
+// label. Either one is an error outside of a loop, or when the label does
+// not name an enclosing loop.
+func (p *Parser) ParseLoopControl() Node {
+	start := p.token
 	p.advance()
-	return FunctionDefenitionNode{lexer.FUNCTION_DEFENITION_NODE,identifier,parameters,ProgramNode{lexer.PROGRAM_NODE,p.ParseMultiline()}}
+
+	label := ""
+	if p.token.Type == lexer.IDENTIFIER {
+		label = p.token.Literal
+		p.advance()
+	}
+
+	keyword := strings.ToLower(start.Type)
+	if len(p.loops) == 0 {
+		return p.ReturnError(keyword+" outside of a loop", start)
+	}
+	if label != "" && !Includes(p.loops, label) {
+		return p.ReturnError("unknown loop label '"+label+"'", p.previous)
+	}
+
+	var node Node
+	if start.Type == lexer.BREAK {
+		node = BreakNode{lexer.BREAK_NODE, label, p.spanFrom(start)}
+	} else {
+		node = ContinueNode{lexer.CONTINUE_NODE, label, p.spanFrom(start)}
+	}
+	p.expectSemicolon()
+	return node
+}
+
+// ParseLoopBody parses the block of a loop, with label in scope for break
+// and continue.
+func (p *Parser) ParseLoopBody(label string) ProgramNode {
+	p.loops = append(p.loops, label)
+	defer func() { p.loops = p.loops[:len(p.loops)-1] }()
+	return p.ParseBlock()
 }
 
-func (p *Parser) ParseFor() interface{} {
+func (p *Parser) ParseFor(label string, start lexer.Token) Node {
 	p.advance()
 
-	if p.token.Type != lexer.LPAREN { return ReturnError("Expected LPAREN For Statement") }
+	if p.token.Type != lexer.LPAREN {
+		return p.ReturnError("Expected LPAREN For Statement", p.token)
+	}
 	p.advance()
 
-	if p.token.Type != lexer.IDENTIFIER { return ReturnError("Expected IDENTIFIER For Statement") }
+	if p.token.Type != lexer.IDENTIFIER {
+		return p.ReturnError("Expected IDENTIFIER For Statement", p.token)
+	}
 	identifier := p.token.Literal
 	p.advance()
 
-	if p.token.Type != lexer.ASSIGN && p.token.Type != lexer.EQ { return ReturnError("Expected ASSIGN or EQ For Statement") }
+	if p.token.Type == lexer.COMMA || p.isWord("in") {
+		return p.ParseForIn(label, start, identifier)
+	}
+
+	if p.token.Type != lexer.ASSIGN && p.token.Type != lexer.EQ {
+		return p.ReturnError("Expected ASSIGN or EQ For Statement", p.token)
+	}
 	p.advance()
 
-	min := p.ParseExpr()
+	min := p.ParseExpression(LOWEST)
 
-	if p.token.Type != lexer.ARROW { return ReturnError("Expected ARROW For Statement") }
+	if p.token.Type != lexer.ARROW && p.token.Type != lexer.INCLUSIVE_ARROW {
+		return p.ReturnError("Expected ARROW or INCLUSIVE_ARROW For Statement", p.token)
+	}
+	inclusive := p.token.Type == lexer.INCLUSIVE_ARROW
 	p.advance()
 
-	max := p.ParseExpr()
+	max := p.ParseExpression(LOWEST)
+	step := p.parseStep()
 
-	if p.token.Type != lexer.RPAREN { return ReturnError("Expected LPAREN For Statement") }
+	if p.token.Type != lexer.RPAREN {
+		return p.ReturnError("Expected RPAREN For Statement", p.token)
+	}
 	p.advance()
 
-	if p.token.Type != lexer.LBRACE { return ReturnError("Expected LBRACE For Statement") }
-	p.advance()
+	if p.token.Type != lexer.LBRACE {
+		return p.ReturnError("Expected LBRACE For Statement", p.token)
+	}
 
-	return ForNode{lexer.FOR_NODE, identifier, min, max,ProgramNode{lexer.PROGRAM_NODE,p.ParseMultiline()}}
+	consequence := p.ParseLoopBody(label)
+	return ForNode{lexer.FOR_NODE, label, identifier, min, max, step, inclusive, consequence, p.spanFrom(start)}
 }
 
-func (p *Parser) ParseWhile() interface{} {
-	p.advance()
-	conditions := p.ParseConditions()
+// ParseForIn parses the rest of a for-in loop, after its first identifier.
+// It loops over a value, or over a range written "a -> b step c".
+func (p *Parser) ParseForIn(label string, start lexer.Token, identifier string) Node {
+	key, value := "", identifier
+	if p.token.Type == lexer.COMMA {
+		p.advance()
+		if p.token.Type != lexer.IDENTIFIER {
+			return p.ReturnError("Expected IDENTIFIER For Statement", p.token)
+		}
+		key, value = identifier, p.token.Literal
+		p.advance()
+	}
 
+	if !p.isWord("in") {
+		return p.ReturnError("Expected in For Statement", p.token)
+	}
 	p.advance()
-	if p.token.Type != lexer.LBRACE { return ReturnError("Expected LBRACE While Statement") }
 
+	iterableStart := p.token
+	iterable := p.ParseExpression(LOWEST)
+	if p.token.Type == lexer.ARROW || p.token.Type == lexer.INCLUSIVE_ARROW {
+		inclusive := p.token.Type == lexer.INCLUSIVE_ARROW
+		p.advance()
+		end := p.ParseExpression(LOWEST)
+		step := p.parseStep()
+		iterable = RangeNode{lexer.RANGE_NODE, iterable, end, step, inclusive, p.spanFrom(iterableStart)}
+	}
+
+	if p.token.Type != lexer.RPAREN {
+		return p.ReturnError("Expected RPAREN For Statement", p.token)
+	}
 	p.advance()
-	consequence := ProgramNode{lexer.PROGRAM_NODE, p.ParseMultiline()}	
 
-	return WhileNode{lexer.WHILE_NODE, conditions, consequence}
+	if p.token.Type != lexer.LBRACE {
+		return p.ReturnError("Expected LBRACE For Statement", p.token)
+	}
+
+	consequence := p.ParseLoopBody(label)
+	return ForInNode{lexer.FOR_IN_NODE, label, key, value, iterable, consequence, p.spanFrom(start)}
 }
 
-func (p *Parser) ParseIf() interface{} {
+// parseStep parses the optional step clause of a range. step is not a
+// keyword, so it can still be used as a variable name.
+func (p *Parser) parseStep() Expression {
+	if !p.isWord("step") {
+		return nil
+	}
 	p.advance()
-	conditions := p.ParseConditions()
+	return p.ParseExpression(LOWEST)
+}
 
-	p.advance()
-	if p.token.Type != lexer.LBRACE { return ReturnError("Expected LBRACE If Statement") }
+// isWord reports whether the current token is the identifier word, for
+// words that are only keywords in some places.
+func (p *Parser) isWord(word string) bool {
+	return p.token.Type == lexer.IDENTIFIER && p.token.Literal == word
+}
 
+func (p *Parser) ParseWhile(label string, start lexer.Token) Node {
 	p.advance()
-	prog := ProgramNode{lexer.PROGRAM_NODE, p.ParseMultiline()}	
+	condition := p.ParseCondition()
+	if p.token.Type == lexer.RPAREN {
+		p.advance()
+	}
+
+	if p.token.Type != lexer.LBRACE {
+		return p.ReturnError("Expected LBRACE While Statement", p.token)
+	}
 
-	return IfNode{lexer.IF_NODE,conditions,prog,ProgramNode{}}
-}
\ No newline at end of file
+	consequence := p.ParseLoopBody(label)
+	return WhileNode{lexer.WHILE_NODE, label, condition, consequence, p.spanFrom(start)}
+}
+
+// ParseIf parses an if statement followed by any number of elif branches
+// and an optional else branch. "else if" is read the same as "elif".
+func (p *Parser) ParseIf() Node {
+	start := p.token
+	var cases []IfConditionNode
+	var alternate ProgramNode
+
+	for {
+		caseStart := p.token
+		p.advance()
+		condition := p.ParseCondition()
+		if p.token.Type == lexer.RPAREN {
+			p.advance()
+		}
+
+		if p.token.Type != lexer.LBRACE {
+			return p.ReturnError("Expected LBRACE If Statement", p.token)
+		}
+		consequence := p.ParseBlock()
+		cases = append(cases, IfConditionNode{lexer.IF_CONDITION_NODE, condition, consequence, p.spanFrom(caseStart)})
+
+		if p.token.Type == lexer.ELSE && p.peekToken().Type == lexer.IF {
+			p.advance()
+		} else if p.token.Type != lexer.ELIF {
+			break
+		}
+	}
+
+	if p.token.Type == lexer.ELSE {
+		p.advance()
+		if p.token.Type != lexer.LBRACE {
+			return p.ReturnError("Expected LBRACE Else Statement", p.token)
+		}
+		alternate = p.ParseBlock()
+	}
+
+	return IfNode{lexer.IF_NODE, cases, alternate, p.spanFrom(start)}
+}
+
+// ParseBlock parses the statements between the current LBRACE and its
+// matching RBRACE, leaving the parser on the token after the RBRACE.
+func (p *Parser) ParseBlock() ProgramNode {
+	start := p.token
+	p.advance()
+	expressions := p.ParseMultiline()
+	if p.token.Type != lexer.RBRACE {
+		p.ReturnError("Expected RBRACE to close block opened at "+start.Pos.String(), p.token)
+		return ProgramNode{lexer.PROGRAM_NODE, expressions, p.spanFrom(start)}
+	}
+	block := ProgramNode{lexer.PROGRAM_NODE, expressions, p.spanTo(start)}
+	p.advance()
+	p.panicking = false
+	return block
+}
@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"terminascript/lexer"
+	"unicode"
+	"unicode/utf8"
+)
+
+func (n ProgramNode) String() string {
+	statements := make([]string, 0, len(n.Expressions))
+	for _, statement := range n.Expressions {
+		statements = append(statements, statementString(statement))
+	}
+	return strings.Join(statements, " ")
+}
+
+// statementString ends a statement with a semicolon unless it ends with a
+// block.
+func statementString(n Node) string {
+	switch n.(type) {
+	case IfNode, ForNode, ForInNode, WhileNode, FunctionDefenitionNode:
+		return n.String()
+	}
+	return n.String() + ";"
+}
+
+func blockString(n ProgramNode) string {
+	if len(n.Expressions) == 0 {
+		return "{}"
+	}
+	return "{ " + n.String() + " }"
+}
+
+func (n ReturnNode) String() string {
+	if n.Expression == nil {
+		return "return"
+	}
+	return "return " + n.Expression.String()
+}
+
+func (n FunctionDefenitionNode) String() string {
+	return "func " + n.Identifier + "(" + expressionsString(n.Parameters) + ") " + blockString(n.Consequence)
+}
+
+func (n FunctionNode) String() string {
+	return "func (" + expressionsString(n.Parameters) + ") " + blockString(n.Consequence)
+}
+
+func (n ArrowFunctionNode) String() string {
+	return "((" + expressionsString(n.Parameters) + ") => " + n.Body.String() + ")"
+}
+
+func (n ForNode) String() string {
+	return labelString(n.Label) + "for (" + n.Identifier + " := " + rangeString(n.MinValue, n.MaxValue, n.Step, n.Inclusive) + ") " + blockString(n.Consequence)
+}
+
+func (n ForInNode) String() string {
+	variables := n.Value
+	if n.Key != "" {
+		variables = n.Key + ", " + n.Value
+	}
+	return labelString(n.Label) + "for (" + variables + " in " + n.Iterable.String() + ") " + blockString(n.Consequence)
+}
+
+func (n RangeNode) String() string {
+	return rangeString(n.Start, n.End, n.Step, n.Inclusive)
+}
+
+func rangeString(start Expression, end Expression, step Expression, inclusive bool) string {
+	arrow := " -> "
+	if inclusive {
+		arrow = " ->= "
+	}
+	str := start.String() + arrow + end.String()
+	if step != nil {
+		str += " step " + step.String()
+	}
+	return str
+}
+
+func (n WhileNode) String() string {
+	return labelString(n.Label) + "while " + conditionString(n.Condition) + " " + blockString(n.Consequence)
+}
+
+func labelString(label string) string {
+	if label == "" {
+		return ""
+	}
+	return label + ": "
+}
+
+func (n BreakNode) String() string {
+	return strings.TrimSpace("break " + n.Label)
+}
+
+func (n ContinueNode) String() string {
+	return strings.TrimSpace("continue " + n.Label)
+}
+
+func (n IfNode) String() string {
+	branches := make([]string, 0, len(n.Cases))
+	for _, branch := range n.Cases {
+		branches = append(branches, branch.String())
+	}
+	str := "if " + strings.Join(branches, " elif ")
+	if n.HasAlternate() {
+		str += " else " + blockString(n.Alternate)
+	}
+	return str
+}
+
+func (n IfConditionNode) String() string {
+	return conditionString(n.Condition) + " " + blockString(n.Consequence)
+}
+
+func conditionString(condition Expression) string {
+	return "(" + condition.String() + ")"
+}
+
+func (n FunctionCallNode) String() string {
+	return n.Function.String() + "(" + expressionsString(n.Parameters) + ")"
+}
+
+func expressionsString(expressions []Expression) string {
+	strs := make([]string, 0, len(expressions))
+	for _, expression := range expressions {
+		strs = append(strs, expression.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (n AssignmentNode) String() string {
+	if !n.Declaration {
+		return n.Target.String() + " " + AssignmentSymbol(n.Op) + " " + n.Value.String()
+	}
+	if n.Value == nil {
+		return "let " + n.Target.String()
+	}
+	return "let " + n.Target.String() + " := " + n.Value.String()
+}
+
+// AssignmentSymbol returns the operator of an assignment whose Op is op.
+func AssignmentSymbol(op string) string {
+	return lexer.Symbol(op) + "="
+}
+
+func (n UpdateNode) String() string {
+	op := lexer.Symbol(n.Op) + lexer.Symbol(n.Op)
+	if n.Prefix {
+		return "(" + op + n.Target.String() + ")"
+	}
+	return "(" + n.Target.String() + op + ")"
+}
+
+func (n ParameterNode) String() string {
+	return n.Identifier
+}
+
+func (n BinaryOperationNode) String() string {
+	return "(" + n.Left.String() + " " + lexer.Symbol(n.Op) + " " + n.Right.String() + ")"
+}
+
+func (n ConditionalNode) String() string {
+	return "(" + n.Condition.String() + " : " + n.Consequence.String() + " ? " + n.Alternate.String() + ")"
+}
+
+func (n UnaryOpNode) String() string {
+	return "(" + lexer.Symbol(n.Op) + n.Right.String() + ")"
+}
+
+func (n VarAccessNode) String() string {
+	return n.Identifier
+}
+
+func (n IntNode) String() string {
+	return strconv.Itoa(n.Value)
+}
+
+// String writes the float so that it is not read back as an int.
+func (n FloatNode) String() string {
+	str := strconv.FormatFloat(n.Value, 'g', -1, 64)
+	if strings.ContainsAny(str, ".e") {
+		return str
+	}
+	return str + ".0"
+}
+
+func (n StringNode) String() string {
+	return `"` + escapeString(n.Value) + `"`
+}
+
+func (n InterpolationNode) String() string {
+	var str strings.Builder
+	str.WriteString(`"`)
+	for i, expression := range n.Expressions {
+		str.WriteString(escapeString(n.Strings[i]))
+		str.WriteString("${" + expression.String() + "}")
+	}
+	str.WriteString(escapeString(n.Strings[len(n.Strings)-1]))
+	str.WriteString(`"`)
+	return str.String()
+}
+
+func (n ArrayNode) String() string {
+	return "[" + expressionsString(n.Elements) + "]"
+}
+
+func (n IndexNode) String() string {
+	return n.Left.String() + "[" + n.Index.String() + "]"
+}
+
+func (n SliceNode) String() string {
+	start, end := "", ""
+	if n.Start != nil {
+		start = n.Start.String()
+	}
+	if n.End != nil {
+		end = n.End.String()
+	}
+	return n.Left.String() + "[" + start + ":" + end + "]"
+}
+
+func (n MapNode) String() string {
+	entries := make([]string, 0, len(n.Keys))
+	for i := range n.Keys {
+		entries = append(entries, n.Keys[i].String()+": "+n.Values[i].String())
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func (n MemberNode) String() string {
+	return n.Left.String() + "." + n.Name
+}
+
+func (n ErrorNode) String() string {
+	return "<error>"
+}
+
+// escapeString escapes text for a double quoted string literal, so that it
+// reads back as the same text.
+func escapeString(text string) string {
+	var str strings.Builder
+	for i := 0; i < len(text); {
+		ch, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case ch == utf8.RuneError && size == 1:
+			fmt.Fprintf(&str, `\x%02x`, text[i])
+		case ch == '\\' || ch == '"':
+			str.WriteRune('\\')
+			str.WriteRune(ch)
+		case ch == '\n':
+			str.WriteString(`\n`)
+		case ch == '\t':
+			str.WriteString(`\t`)
+		case ch == '\r':
+			str.WriteString(`\r`)
+		case ch == 0:
+			str.WriteString(`\0`)
+		case ch == '$' && strings.HasPrefix(text[i+size:], "{"):
+			str.WriteString(`\$`)
+		case ch < utf8.RuneSelf && !unicode.IsPrint(ch):
+			fmt.Fprintf(&str, `\x%02x`, ch)
+		case !unicode.IsPrint(ch):
+			fmt.Fprintf(&str, `\u{%x}`, ch)
+		default:
+			str.WriteRune(ch)
+		}
+		i += size
+	}
+	return str.String()
+}